@@ -0,0 +1,177 @@
+// Package badges awards achievements. Badges are defined in Registry as
+// rules over a user's models.BadgeStats; the Evaluator checks them when
+// something happens to a user and in a periodic backfill over everyone
+// who posted. Once earned a badge is kept, even if the stats that earned it
+// later drop.
+package badges
+
+import (
+	"backend/models"
+	"database/sql"
+	"errors"
+)
+
+// Event is a domain event that may earn the user it concerns a badge.
+type Event string
+
+const (
+	EventThreadPosted   Event = "thread_posted"
+	EventReplyPosted    Event = "reply_posted"
+	EventUpvoted        Event = "upvoted"
+	EventAnswerAccepted Event = "answer_accepted"
+	EventThreadSolved   Event = "thread_solved"
+)
+
+type Badge struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// On lists the events after which the rule is checked.
+	On     []Event                        `json:"-"`
+	Earned func(s models.BadgeStats) bool `json:"-"`
+}
+
+// Registry holds every badge, in display order. IDs are stored with awarded
+// badges and must not change.
+var Registry = []*Badge{
+	{
+		ID:          "first-thread",
+		Name:        "First question",
+		Description: "Started a thread.",
+		On:          []Event{EventThreadPosted},
+		Earned:      func(s models.BadgeStats) bool { return s.ThreadsAsked >= 1 },
+	},
+	{
+		ID:          "first-reply",
+		Name:        "First reply",
+		Description: "Replied to a thread.",
+		On:          []Event{EventReplyPosted},
+		Earned:      func(s models.BadgeStats) bool { return s.RepliesPosted >= 1 },
+	},
+	{
+		ID:          "first-accepted-answer",
+		Name:        "First accepted answer",
+		Description: "Wrote a reply that was accepted as the answer.",
+		On:          []Event{EventAnswerAccepted},
+		Earned:      func(s models.BadgeStats) bool { return s.AcceptedAnswers >= 1 },
+	},
+	{
+		ID:          "solved-10",
+		Name:        "Solved 10 threads",
+		Description: "Wrote the accepted answer in 10 threads.",
+		On:          []Event{EventAnswerAccepted},
+		Earned:      func(s models.BadgeStats) bool { return s.ThreadsAnswered >= 10 },
+	},
+	{
+		ID:          "helped-5-categories",
+		Name:        "Helped in 5 categories",
+		Description: "Replied to other students' threads in 5 different categories.",
+		On:          []Event{EventReplyPosted},
+		Earned:      func(s models.BadgeStats) bool { return s.CategoriesHelped >= 5 },
+	},
+	{
+		ID:          "closed-the-loop",
+		Name:        "Closed the loop",
+		Description: "Saw 5 of your threads through to solved.",
+		On:          []Event{EventThreadSolved},
+		Earned:      func(s models.BadgeStats) bool { return s.SolvedThreads >= 5 },
+	},
+	{
+		ID:          "well-received",
+		Name:        "Well received",
+		Description: "Got 25 upvotes on your threads and replies.",
+		On:          []Event{EventUpvoted},
+		Earned:      func(s models.BadgeStats) bool { return s.UpvotesReceived >= 25 },
+	},
+	{
+		ID:          "trusted",
+		Name:        "Trusted",
+		Description: "Reached 500 reputation.",
+		On:          []Event{EventUpvoted, EventAnswerAccepted, EventThreadSolved},
+		Earned:      func(s models.BadgeStats) bool { return s.Reputation >= 500 },
+	},
+}
+
+// Lookup returns the badge with id, or nil.
+func Lookup(id string) *Badge {
+	for _, b := range Registry {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func (b *Badge) triggeredBy(event Event) bool {
+	for _, e := range b.On {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type Evaluator struct {
+	DB *models.DBModel
+}
+
+// Evaluate awards the user every badge they earned that event could have
+// earned them, or every badge at all when event is empty, and returns the
+// ones they did not have before.
+func (e *Evaluator) Evaluate(userID int, event Event) ([]*Badge, error) {
+	var candidates []*Badge
+	for _, b := range Registry {
+		if event == "" || b.triggeredBy(event) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	stats, err := e.DB.GetBadgeStats(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var awarded []*Badge
+	for _, b := range candidates {
+		if !b.Earned(*stats) {
+			continue
+		}
+		isNew, err := e.DB.AwardBadge(userID, b.ID)
+		if err != nil {
+			return awarded, err
+		}
+		if isNew {
+			awarded = append(awarded, b)
+		}
+	}
+
+	return awarded, nil
+}
+
+// Backfill evaluates every badge for every user who posted, catching up on
+// content from before a badge existed. It returns how many badges were
+// newly awarded.
+func (e *Evaluator) Backfill() (int, error) {
+	ids, err := e.DB.ContributorIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, id := range ids {
+		awarded, err := e.Evaluate(id, "")
+		total += len(awarded)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Personal access tokens start with this prefix so checkToken can tell them
+// from JWTs and secret scanners can spot leaked ones.
+const accessTokenPrefix = "stp_"
+
+const (
+	defaultAccessTokenDays = 30
+	maxAccessTokenDays     = 365
+)
+
+type AccessTokenPayload struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func (app *application) createAccessToken(w http.ResponseWriter, r *http.Request) {
+	var payload AccessTokenPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" || len(payload.Name) > 100 {
+		app.errorJSON(w, errors.New("name must be between 1 and 100 characters"))
+		return
+	}
+
+	if len(payload.Scopes) == 0 {
+		app.errorJSON(w, errors.New("at least one scope is required"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !models.ValidAccessScope(scope) {
+			app.errorJSON(w, errors.New("unknown scope "+scope))
+			return
+		}
+		if scope == models.AccessScopeAdmin && !models.RoleAtLeast(app.authenticatedRole(r), models.RoleModerator) {
+			app.errorJSON(w, errors.New("forbidden - admin scope requires a moderator or admin"), http.StatusForbidden)
+			return
+		}
+	}
+
+	if payload.ExpiresInDays == 0 {
+		payload.ExpiresInDays = defaultAccessTokenDays
+	}
+	if payload.ExpiresInDays < 1 || payload.ExpiresInDays > maxAccessTokenDays {
+		app.errorJSON(w, errors.New("expires_in_days must be between 1 and "+strconv.Itoa(maxAccessTokenDays)))
+		return
+	}
+
+	secret, _, err := newTokenSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	plain := accessTokenPrefix + secret
+
+	token := &models.PersonalAccessToken{
+		UserID:    app.authenticatedUserID(r),
+		Name:      payload.Name,
+		TokenHash: hashToken(plain),
+		Scopes:    payload.Scopes,
+		ExpiresAt: time.Now().Add(time.Duration(payload.ExpiresInDays) * 24 * time.Hour),
+	}
+
+	err = app.models.DB.InsertAccessToken(token)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The plain token is only ever shown in this response.
+	response := map[string]interface{}{
+		"token":        plain,
+		"access_token": token,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, response, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) listAccessTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.models.DB.AccessTokensForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, tokens, "tokens")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) revokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.RevokeAccessToken(id, app.authenticatedUserID(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("token not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const purgeBatchSize = 100
+
+// AccountExport is everything we keep about a user that they can take with
+// them. Credentials and sessions are left out.
+type AccountExport struct {
+	ExportedAt     time.Time                 `json:"exported_at"`
+	Profile        *models.Profile           `json:"profile"`
+	Account        AccountDetails            `json:"account"`
+	Settings       *models.UserSettings      `json:"settings"`
+	Threads        []*models.Thread          `json:"threads"`
+	Replies        []*models.Reply           `json:"replies"`
+	StarredThreads []*models.Thread          `json:"starred_threads"`
+	Votes          []*models.Vote            `json:"votes"`
+	Badges         []*models.UserBadge       `json:"badges"`
+	Reputation     []*models.ReputationEvent `json:"reputation"`
+}
+
+type AccountDetails struct {
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// exportAccount sends the caller's data as a JSON file download.
+func (app *application) exportAccount(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.models.DB.GetUserByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	export := AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Account: AccountDetails{
+			Email:           user.Email,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+			Role:            user.Role,
+			TOTPEnabled:     user.TOTPEnabled,
+			CreatedAt:       user.CreatedAt,
+		},
+	}
+
+	export.Profile, err = app.models.DB.GetProfile(userID)
+	if err == nil {
+		export.Threads, err = app.models.DB.YourThreads(userID)
+	}
+	if err == nil {
+		export.Replies, err = app.models.DB.RepliesByAuthor(userID)
+	}
+	if err == nil {
+		export.StarredThreads, err = app.models.DB.GetStarredThreads(userID)
+	}
+	if err == nil {
+		export.Settings, err = app.models.DB.GetUserSettings(userID)
+	}
+	if err == nil {
+		export.Votes, err = app.models.DB.VotesByUser(userID)
+	}
+	if err == nil {
+		export.Badges, err = app.models.DB.UserBadges(userID)
+	}
+	if err == nil {
+		export.Reputation, err = app.models.DB.ReputationHistory(userID, 0)
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if export.Threads == nil {
+		export.Threads = []*models.Thread{}
+	}
+	if export.StarredThreads == nil {
+		export.StarredThreads = []*models.Thread{}
+	}
+
+	filename := fmt.Sprintf("study-threads-%s-%s.json", user.Username, export.ExportedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err = app.writeJSON(w, http.StatusOK, export, "export")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+type DeleteAccountPayload struct {
+	Mode     string `json:"mode"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// requestAccountDeletion schedules the caller's account for purging once
+// the grace period is over. Until then it can be cancelled.
+func (app *application) requestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	var payload DeleteAccountPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	if payload.Mode == "" {
+		payload.Mode = models.DeletionAnonymize
+	}
+	if payload.Mode != models.DeletionAnonymize && payload.Mode != models.DeletionRemove {
+		app.errorJSON(w, errors.New("mode must be anonymize or remove"))
+		return
+	}
+
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// A stolen session alone must not be enough to destroy the account.
+	if user.Password != "" {
+		match, _, _ := app.passwords.Verify(user.Password, payload.Password)
+		if !match {
+			app.errorJSON(w, errors.New("invalid password"), http.StatusForbidden)
+			return
+		}
+	}
+	if user.TOTPEnabled && !app.checkSecondFactor(user, payload.Code, "") {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusForbidden)
+		return
+	}
+
+	purgeAfter := time.Now().Add(app.config.deletion.grace)
+
+	err = app.models.DB.ScheduleAccountDeletion(user.UserID, payload.Mode, purgeAfter)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	deletion, err := app.models.DB.GetAccountDeletion(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, deletion, "deletion")
+}
+
+func (app *application) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	err := app.models.DB.CancelAccountDeletion(app.authenticatedUserID(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("no deletion is pending"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok := jsonResponse{OK: true}
+
+	app.writeJSON(w, http.StatusOK, ok, "response")
+}
+
+// purgeDeletedAccounts runs for the lifetime of the server, purging the
+// accounts whose grace period is over every interval.
+func (app *application) purgeDeletedAccounts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeDue()
+		<-ticker.C
+	}
+}
+
+func (app *application) purgeDue() {
+	due, err := app.models.DB.DueAccountDeletions(purgeBatchSize)
+	if err != nil {
+		app.logger.Println("account purge:", err)
+		return
+	}
+
+	for _, d := range due {
+		err := app.models.DB.PurgeUser(d.UserID, d.Mode)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			app.logger.Printf("account purge of user %d: %v", d.UserID, err)
+			continue
+		}
+
+		app.models.DB.InsertAuthEvent(models.AuthEvent{
+			Event:    "account_deleted",
+			Username: d.Username,
+			Detail:   d.Mode,
+		})
+	}
+}
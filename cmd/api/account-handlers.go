@@ -0,0 +1,283 @@
+package main
+
+import (
+	"backend/mailer"
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	activationTTL        = 14 * 24 * time.Hour
+)
+
+// sendMail delivers msg in the background so responses neither wait for the
+// mail server nor reveal through their timing whether an address exists.
+func (app *application) sendMail(msg mailer.Message) {
+	go func() {
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.logger.Printf("sending %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// mailToken creates a single-use token of scope for user and returns the
+// frontend link carrying it.
+func (app *application) mailToken(user *models.User, scope, path string, ttl time.Duration) (string, error) {
+	token, hash, err := newTokenSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = app.models.DB.InsertUserToken(hash, user.UserID, scope, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s?token=%s", app.config.frontendURL, path, url.QueryEscape(token)), nil
+}
+
+func (app *application) sendVerificationEmail(user *models.User) {
+	link, err := app.mailToken(user, models.ScopeEmailVerification, "/verify-email", emailVerificationTTL)
+	if err != nil {
+		app.logger.Println(err)
+		return
+	}
+
+	app.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %s.\n", user.Username, link, emailVerificationTTL),
+	})
+}
+
+func (app *application) sendPasswordResetEmail(user *models.User) {
+	link, err := app.mailToken(user, models.ScopePasswordReset, "/reset-password", passwordResetTTL)
+	if err != nil {
+		app.logger.Println(err)
+		return
+	}
+
+	app.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset your password. If that was you, open the link below:\n\n%s\n\n"+
+			"The link expires in %s and works once. If you did not ask for this you can ignore this email.\n",
+			user.Username, link, passwordResetTTL),
+	})
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+// forgotPassword always answers the same way so it cannot be used to find
+// out which addresses have accounts.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil || payload.Email == "" {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	user, err := app.models.DB.GetUserByEmail(payload.Email)
+	if err == nil {
+		app.sendPasswordResetEmail(user)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		app.logger.Println(err)
+	}
+
+	ok := jsonResponse{OK: true, Message: "if the address belongs to an account, a reset link is on its way"}
+
+	app.writeJSON(w, http.StatusAccepted, ok, "response")
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	hash := hashToken(payload.Token)
+
+	user, err := app.models.DB.GetUserForToken(hash, models.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired reset token"))
+		return
+	}
+
+	err = validatePassword(user.Username, payload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.models.DB.ConsumeUserToken(hash, models.ScopePasswordReset)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired reset token"))
+		return
+	}
+
+	hashedPassword, err := app.passwords.Hash(payload.Password)
+	if err != nil {
+		app.errorJSON(w, errors.New("error hashing password"))
+		return
+	}
+
+	err = app.models.DB.SetUserPassword(user.UserID, hashedPassword)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The reset link proved control of the mailbox, and whoever knew the old
+	// password must not stay signed in.
+	app.models.DB.MarkEmailVerified(user.UserID)
+	app.models.DB.DeleteUserTokens(user.UserID, models.ScopePasswordReset)
+	app.models.DB.RevokeUserSessions(user.UserID)
+	app.clearLoginFailures(user.Username)
+
+	ok := jsonResponse{OK: true}
+
+	app.writeJSON(w, http.StatusOK, ok, "response")
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyEmailPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	userID, err := app.models.DB.ConsumeUserToken(hashToken(payload.Token), models.ScopeEmailVerification)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired verification token"))
+		return
+	}
+
+	err = app.models.DB.MarkEmailVerified(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.models.DB.DeleteUserTokens(userID, models.ScopeEmailVerification)
+
+	ok := jsonResponse{OK: true}
+
+	app.writeJSON(w, http.StatusOK, ok, "response")
+}
+
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.Email == "" {
+		app.errorJSON(w, errors.New("no email address on the account"))
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		app.errorJSON(w, errors.New("email address already verified"))
+		return
+	}
+
+	app.sendVerificationEmail(user)
+
+	ok := jsonResponse{OK: true}
+
+	app.writeJSON(w, http.StatusAccepted, ok, "response")
+}
+
+// sendActivationEmail mails a roster import's activation link to a student.
+func (app *application) sendActivationEmail(email, name, link string) {
+	app.sendMail(mailer.Message{
+		To:      email,
+		Subject: "Activate your account",
+		Body: fmt.Sprintf("Hi %s,\n\nan account was created for you on the course forum. Open the link below to choose a password:\n\n%s\n\n"+
+			"The link expires in %s.\n", name, link, activationTTL),
+	})
+}
+
+type ActivatePayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// activateAccount lets a student from an imported roster choose a password
+// and signs them in.
+func (app *application) activateAccount(w http.ResponseWriter, r *http.Request) {
+	var payload ActivatePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	hash := hashToken(payload.Token)
+
+	user, err := app.models.DB.GetUserForToken(hash, models.ScopeActivation)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired activation link"))
+		return
+	}
+
+	err = validatePassword(user.Username, payload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.models.DB.ConsumeUserToken(hash, models.ScopeActivation)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired activation link"))
+		return
+	}
+
+	hashedPassword, err := app.passwords.Hash(payload.Password)
+	if err != nil {
+		app.errorJSON(w, errors.New("error hashing password"))
+		return
+	}
+
+	err = app.models.DB.SetUserPassword(user.UserID, hashedPassword)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The link was mailed to the roster address.
+	app.models.DB.MarkEmailVerified(user.UserID)
+	app.models.DB.DeleteUserTokens(user.UserID, models.ScopeActivation)
+
+	app.startSession(w, r, user)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"backend/models"
+	"backend/roster"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type RolePayload struct {
+	Role string `json:"role"`
+}
+
+func (app *application) grantRole(w http.ResponseWriter, r *http.Request) {
+	var payload RolePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	if !models.ValidRole(payload.Role) {
+		app.errorJSON(w, errors.New("unknown role"))
+		return
+	}
+
+	app.setRole(w, r, payload.Role)
+}
+
+func (app *application) revokeRole(w http.ResponseWriter, r *http.Request) {
+	app.setRole(w, r, models.RoleUser)
+}
+
+func (app *application) setRole(w http.ResponseWriter, r *http.Request, role string) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	userID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Keep at least the caller able to undo mistakes.
+	if userID == app.authenticatedUserID(r) {
+		app.errorJSON(w, errors.New("cannot change your own role"), http.StatusForbidden)
+		return
+	}
+
+	err = app.models.DB.SetUserRole(userID, role)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.logger.Printf("user %d set role of user %d to %s", app.authenticatedUserID(r), userID, role)
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// authEvents lists the sign-in audit log, e.g. ?event=lockout&limit=50.
+func (app *application) authEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			app.errorJSON(w, errors.New("limit must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+
+	events, err := app.models.DB.AuthEvents(r.URL.Query().Get("event"), limit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, events, "events")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+const maxRosterBytes = 1 << 20
+
+// importRoster creates or updates the accounts of a class roster sent as a
+// CSV body (see package roster). With ?send_emails=true every student still
+// to activate their account is mailed their link.
+func (app *application) importRoster(w http.ResponseWriter, r *http.Request) {
+	rows, failed, err := roster.Parse(http.MaxBytesReader(w, r.Body, maxRosterBytes))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	importer := &roster.Importer{
+		DB:            &app.models.DB,
+		FrontendURL:   app.config.frontendURL,
+		ActivationTTL: activationTTL,
+	}
+	results := append(importer.Import(rows), failed...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Line < results[j].Line })
+
+	if r.URL.Query().Get("send_emails") == "true" {
+		names := map[int]string{}
+		for _, row := range rows {
+			names[row.Line] = row.Name
+		}
+		for _, result := range results {
+			if result.ActivationURL != "" {
+				app.sendActivationEmail(result.Email, names[result.Line], result.ActivationURL)
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"summary": roster.Summary(results),
+		"results": results,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "roster")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// listUsers searches the users, e.g.
+// ?q=ann&role=moderator&joined_after=2024-09-01&active_since=2024-10-01&sort=last_active.
+// Dates are YYYY-MM-DD or RFC 3339; inactive_since lists users not seen
+// since then and disabled=true|false filters on the account state.
+func (app *application) listUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	search := models.UserSearch{
+		Query:  strings.TrimSpace(query.Get("q")),
+		Role:   query.Get("role"),
+		Sort:   query.Get("sort"),
+		Limit:  50,
+		Offset: 0,
+	}
+
+	if search.Role != "" && !models.ValidRole(search.Role) {
+		app.errorJSON(w, errors.New("unknown role"))
+		return
+	}
+	if search.Sort != "" && !models.ValidUserSort(search.Sort) {
+		app.errorJSON(w, errors.New("sort must be one of joined, username, last_active, threads, replies"))
+		return
+	}
+
+	dates := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"joined_after", &search.JoinedAfter},
+		{"joined_before", &search.JoinedBefore},
+		{"active_since", &search.ActiveSince},
+		{"inactive_since", &search.InactiveSince},
+	}
+	for _, d := range dates {
+		v := query.Get(d.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseDate(v)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("%s must be a date like 2006-01-02", d.name))
+			return
+		}
+		*d.dst = t
+	}
+
+	if v := query.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			app.errorJSON(w, errors.New("disabled must be true or false"))
+			return
+		}
+		search.Disabled = &disabled
+	}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			app.errorJSON(w, errors.New("limit must be between 1 and 200"))
+			return
+		}
+		search.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			app.errorJSON(w, errors.New("offset must not be negative"))
+			return
+		}
+		search.Offset = n
+	}
+
+	users, total, err := app.models.DB.SearchUsers(search)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  search.Limit,
+		"offset": search.Offset,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "result")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// userParam loads the user named by the :id route parameter, answering
+// the request itself when that fails.
+func (app *application) userParam(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	userID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	user, err := app.models.DB.GetUserByID(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+const adminRecentItems = 10
+
+// getUserDetails shows an account with its activity summary and most recent
+// threads and replies.
+func (app *application) getUserDetails(w http.ResponseWriter, r *http.Request) {
+	user, found := app.userParam(w, r)
+	if !found {
+		return
+	}
+
+	activity, err := app.models.DB.GetUserActivity(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	threads, err := app.models.DB.YourThreads(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if len(threads) > adminRecentItems {
+		threads = threads[:adminRecentItems]
+	}
+
+	replies, err := app.models.DB.RepliesByAuthor(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if len(replies) > adminRecentItems {
+		replies = replies[:adminRecentItems]
+	}
+
+	response := map[string]interface{}{
+		"user":           user,
+		"activity":       activity,
+		"recent_threads": threads,
+		"recent_replies": replies,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "result")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// forceSignOut ends every session of the user. Personal access tokens are
+// left alone; those are revoked by their owner.
+func (app *application) forceSignOut(w http.ResponseWriter, r *http.Request) {
+	user, found := app.userParam(w, r)
+	if !found {
+		return
+	}
+
+	err := app.models.DB.RevokeUserSessions(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.logger.Printf("user %d signed out user %d everywhere", app.authenticatedUserID(r), user.UserID)
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// triggerPasswordReset mails the user the same reset link forgotPassword
+// would.
+func (app *application) triggerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, found := app.userParam(w, r)
+	if !found {
+		return
+	}
+
+	if user.Email == "" {
+		app.errorJSON(w, errors.New("user has no email address"), http.StatusConflict)
+		return
+	}
+
+	app.sendPasswordResetEmail(user)
+	app.logger.Printf("user %d sent a password reset to user %d", app.authenticatedUserID(r), user.UserID)
+
+	ok := jsonResponse{OK: true}
+
+	err := app.writeJSON(w, http.StatusAccepted, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+type DisablePayload struct {
+	Reason string `json:"reason"`
+}
+
+// disableUser blocks sign-in and ends the user's sessions; their access
+// tokens stop working until the account is enabled again.
+func (app *application) disableUser(w http.ResponseWriter, r *http.Request) {
+	var payload DisablePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	app.setDisabled(w, r, true, strings.TrimSpace(payload.Reason))
+}
+
+func (app *application) enableUser(w http.ResponseWriter, r *http.Request) {
+	app.setDisabled(w, r, false, "")
+}
+
+func (app *application) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool, reason string) {
+	user, found := app.userParam(w, r)
+	if !found {
+		return
+	}
+
+	if user.UserID == app.authenticatedUserID(r) {
+		app.errorJSON(w, errors.New("cannot disable your own account"), http.StatusForbidden)
+		return
+	}
+
+	err := app.models.DB.SetUserDisabled(user.UserID, disabled, reason)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	event := "account_enabled"
+	if disabled {
+		event = "account_disabled"
+		err = app.models.DB.RevokeUserSessions(user.UserID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	app.models.DB.InsertAuthEvent(models.AuthEvent{
+		Event:    event,
+		Username: user.Username,
+		IP:       clientIP(r),
+		Detail:   strings.TrimSpace(fmt.Sprintf("by user %d %s", app.authenticatedUserID(r), reason)),
+	})
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
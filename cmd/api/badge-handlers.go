@@ -0,0 +1,159 @@
+package main
+
+import (
+	"backend/badges"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BadgeResponse is a badge with, depending on the endpoint, how many users
+// hold it or when the user earned it.
+type BadgeResponse struct {
+	*badges.Badge
+	Holders   *int       `json:"holders,omitempty"`
+	AwardedAt *time.Time `json:"awarded_at,omitempty"`
+}
+
+// awardBadges evaluates the user's badges for event in the background, so
+// a slow evaluation never holds up the request that caused it.
+func (app *application) awardBadges(userID int, event badges.Event) {
+	go func() {
+		awarded, err := app.badges.Evaluate(userID, event)
+		for _, b := range awarded {
+			app.logger.Printf("user %d earned badge %s", userID, b.ID)
+		}
+		if err != nil {
+			app.logger.Printf("evaluating badges of user %d: %v", userID, err)
+		}
+	}()
+}
+
+func (app *application) backfillBadges(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.badges.Backfill()
+		if err != nil {
+			app.logger.Println("badge backfill:", err)
+		}
+		if n > 0 {
+			app.logger.Printf("badge backfill awarded %d badges", n)
+		}
+		<-ticker.C
+	}
+}
+
+// listBadges lists every badge with the number of holders.
+func (app *application) listBadges(w http.ResponseWriter, r *http.Request) {
+	counts, err := app.models.DB.BadgeCounts()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	response := make([]BadgeResponse, len(badges.Registry))
+	for i, b := range badges.Registry {
+		n := counts[b.ID]
+		response[i] = BadgeResponse{Badge: b, Holders: &n}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "badges")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getBadgeHolders lists everyone holding a badge, earliest first, e.g.
+// ?limit=50&offset=100.
+func (app *application) getBadgeHolders(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	badge := badges.Lookup(params.ByName("id"))
+	if badge == nil {
+		app.errorJSON(w, errors.New("badge not found"), http.StatusNotFound)
+		return
+	}
+
+	limit, offset := 100, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			app.errorJSON(w, errors.New("limit must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			app.errorJSON(w, errors.New("offset must not be negative"))
+			return
+		}
+		offset = n
+	}
+
+	holders, err := app.models.DB.BadgeHolders(badge.ID, limit, offset)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"badge":   badge,
+		"holders": holders,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "result")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getUserBadges(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	userID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.models.DB.GetUserByID(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	awarded, err := app.models.DB.UserBadges(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Badges dropped from the registry are no longer shown.
+	response := []BadgeResponse{}
+	for _, a := range awarded {
+		if b := badges.Lookup(a.BadgeID); b != nil {
+			awardedAt := a.AwardedAt
+			response = append(response, BadgeResponse{Badge: b, AwardedAt: &awardedAt})
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "badges")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Browsers may keep their session in cookies instead of handing the bearer
+// token to JavaScript. The access token and refresh token then live in
+// HttpOnly cookies, and because the browser attaches those to any request,
+// state-changing requests must echo the readable CSRF cookie in a header
+// (double-submit) to prove they come from our own frontend.
+const (
+	sessionCookie = "session"
+	refreshCookie = "refresh_token"
+	csrfCookie    = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// wantsCookieSession reports whether tokens for this request are to be
+// delivered as cookies: either the client asked with ?session=cookie when
+// signing in, or it is already using a cookie session.
+func (app *application) wantsCookieSession(r *http.Request) bool {
+	if cookie, _ := r.Context().Value(cookieSessionContextKey).(bool); cookie {
+		return true
+	}
+	return r.URL.Query().Get("session") == "cookie"
+}
+
+func withCookieSession(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cookieSessionContextKey, true))
+}
+
+func (app *application) newCookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(app.config.cookie.sameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   app.config.cookie.domain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   app.config.env != "development" || sameSite == http.SameSiteNoneMode,
+		SameSite: sameSite,
+	}
+}
+
+// setSessionCookies stores a session in the browser. The refresh token is
+// only sent to the refresh endpoint.
+func (app *application) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken, csrfToken string) {
+	http.SetCookie(w, app.newCookie(sessionCookie, accessToken, "/", app.config.jwt.accessTTL, true))
+	http.SetCookie(w, app.newCookie(refreshCookie, refreshToken, "/v1/token", app.config.jwt.refreshTTL, true))
+	http.SetCookie(w, app.newCookie(csrfCookie, csrfToken, "/", app.config.jwt.refreshTTL, false))
+}
+
+func (app *application) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, app.newCookie(sessionCookie, "", "/", -1, true))
+	http.SetCookie(w, app.newCookie(refreshCookie, "", "/v1/token", -1, true))
+	http.SetCookie(w, app.newCookie(csrfCookie, "", "/", -1, false))
+}
+
+// csrfToken returns the request's CSRF cookie, so that refreshing a session
+// keeps the token other tabs already read.
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// validCSRF reports whether a cookie-authenticated request may proceed.
+// Safe methods need no token.
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	return hasCSRFToken(r)
+}
+
+func hasCSRFToken(r *http.Request) bool {
+	want := csrfToken(r)
+	got := r.Header.Get(csrfHeader)
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// requireCSRF demands the CSRF token from cookie sessions whatever the
+// method, for the legacy GET routes that change state. It must run after
+// checkToken.
+func (app *application) requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, _ := r.Context().Value(cookieSessionContextKey).(bool); cookie && !hasCSRFToken(r) {
+			app.errorJSON(w, errors.New("forbidden - missing or invalid CSRF token"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedOrigin reports whether origin may make credentialed requests.
+func (app *application) trustedOrigin(origin string) bool {
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
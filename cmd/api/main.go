@@ -1,6 +1,9 @@
 package main
 
 import (
+	"backend/badges"
+	"backend/hasher"
+	"backend/mailer"
 	"backend/models"
 	"context"
 	"database/sql"
@@ -8,7 +11,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -23,7 +28,71 @@ type config struct {
 		dsn string
 	}
 	jwt struct {
-		secret string
+		secret     string
+		keysFile   string
+		issuer     string
+		audience   string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	mail struct {
+		smtpHost        string
+		smtpPort        int
+		smtpUsername    string
+		smtpPassword    string
+		sender          string
+		file            string
+		requireVerified bool
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+		scopes       string
+	}
+	webauthn struct {
+		rpID    string
+		rpName  string
+		origins string
+	}
+	cookie struct {
+		domain   string
+		sameSite string
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	passwords struct {
+		hasher       string
+		bcryptCost   int
+		argonTime    uint
+		argonMemory  uint
+		argonThreads uint
+	}
+	pow struct {
+		difficulty    int
+		maxDifficulty int
+		signupRate    int
+		window        time.Duration
+		newAccountAge time.Duration
+	}
+	deletion struct {
+		grace         time.Duration
+		purgeInterval time.Duration
+	}
+	badges struct {
+		backfillInterval time.Duration
+	}
+	replies struct {
+		maxDepth int
+	}
+	frontendURL string
+	login       struct {
+		maxUserFailures int
+		maxIPFailures   int
+		lockout         time.Duration
+		maxLockout      time.Duration
 	}
 }
 
@@ -34,9 +103,16 @@ type AppStatus struct {
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models models.Models
+	config    config
+	logger    *log.Logger
+	models    models.Models
+	keys      *keySet
+	mailer    mailer.Mailer
+	passwords *hasher.Policy
+	badges    *badges.Evaluator
+	pow       *powGuard
+	oidc      *oidcProvider
+	webauthn  *webauthnRelyingParty
 }
 
 const (
@@ -56,11 +132,82 @@ func main() {
 	flag.IntVar(&cfg.port, "port", 4000, "Server port to listen on")
 	flag.StringVar(&cfg.env, "env", "development", "Application environment (development|production)")
 	flag.StringVar(&cfg.db.dsn, "dsn", psqlconn, "Postgres conncection string")
-	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "2dce505d96a53c5768052ee90f3df2055657518dad489160df9913f66042e160", "Secret")
+	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "HS256 secret, used when no -jwt-keys file is given")
+	flag.StringVar(&cfg.jwt.keysFile, "jwt-keys", "", "JSON file with the signing keys (see keys.go)")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "study-threads", "Issuer of the tokens we sign")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "study-threads", "Audience of the tokens we sign and accept")
+	flag.DurationVar(&cfg.jwt.accessTTL, "access-token-ttl", 15*time.Minute, "Lifetime of access tokens")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Lifetime of refresh tokens and their sessions")
+	flag.IntVar(&cfg.login.maxUserFailures, "login-max-failures", 5, "Failed sign-ins per username before it is locked")
+	flag.IntVar(&cfg.login.maxIPFailures, "login-max-ip-failures", 20, "Failed sign-ins per client address before it is locked")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", time.Minute, "First lockout, doubled on every further failure")
+	flag.DurationVar(&cfg.login.maxLockout, "login-max-lockout", time.Hour, "Longest lockout")
+	flag.StringVar(&cfg.mail.smtpHost, "smtp-host", "", "SMTP relay; mail is written to -mail-file when empty")
+	flag.IntVar(&cfg.mail.smtpPort, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&cfg.mail.smtpUsername, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.mail.smtpPassword, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.mail.sender, "mail-sender", "Study Threads <no-reply@localhost>", "From address of outgoing mail")
+	flag.StringVar(&cfg.mail.file, "mail-file", "", "File to append outgoing mail to instead of sending it (default stdout)")
+	flag.BoolVar(&cfg.mail.requireVerified, "require-verified-email", false, "Require a verified email address before users may post")
+	flag.StringVar(&cfg.frontendURL, "frontend-url", "http://localhost:3000", "Base URL of the frontend, used in emailed links")
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer for single sign-on; disabled when empty")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/oidc/callback", "Callback URL registered with the provider")
+	flag.StringVar(&cfg.oidc.scopes, "oidc-scopes", "openid profile email", "Scopes requested from the provider")
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", "localhost", "WebAuthn relying party ID, the site's registrable domain")
+	flag.StringVar(&cfg.webauthn.rpName, "webauthn-rp-name", "Study Threads", "Site name shown by authenticators")
+	flag.StringVar(&cfg.webauthn.origins, "webauthn-origins", "http://localhost:3000", "Comma-separated origins passkey ceremonies may come from")
+	flag.StringVar(&cfg.cookie.domain, "cookie-domain", "", "Domain attribute of session cookies, empty for the API host only")
+	flag.StringVar(&cfg.cookie.sameSite, "cookie-samesite", "lax", "SameSite attribute of session cookies (lax|strict|none)")
+	flag.Func("cors-trusted-origins", "Comma-separated origins allowed to send credentialed requests (default http://localhost:3000)", func(s string) error {
+		cfg.cors.trustedOrigins = strings.Split(s, ",")
+		return nil
+	})
+	flag.StringVar(&cfg.passwords.hasher, "password-hasher", "argon2id", "Algorithm for new password hashes (argon2id|bcrypt); older hashes are upgraded on sign-in")
+	flag.IntVar(&cfg.passwords.bcryptCost, "bcrypt-cost", 12, "bcrypt cost")
+	flag.UintVar(&cfg.passwords.argonTime, "argon2-time", 3, "argon2id iterations")
+	flag.UintVar(&cfg.passwords.argonMemory, "argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.passwords.argonThreads, "argon2-threads", 2, "argon2id parallelism")
+	flag.IntVar(&cfg.pow.difficulty, "pow-difficulty", 16, "Leading zero bits a signup proof of work needs at normal signup rates")
+	flag.IntVar(&cfg.pow.maxDifficulty, "pow-max-difficulty", 24, "Upper bound for the automatically raised signup difficulty")
+	flag.IntVar(&cfg.pow.signupRate, "pow-signup-rate", 20, "Signups per -pow-window above which difficulty rises, 0 to keep it fixed")
+	flag.DurationVar(&cfg.pow.window, "pow-window", 10*time.Minute, "Window the signup rate is measured over")
+	flag.DurationVar(&cfg.pow.newAccountAge, "pow-new-account-age", 0, "Accounts younger than this solve a proof of work per new thread, 0 to disable")
+	flag.DurationVar(&cfg.deletion.grace, "deletion-grace", 14*24*time.Hour, "How long a requested account deletion can be cancelled before the account is purged")
+	flag.DurationVar(&cfg.deletion.purgeInterval, "purge-interval", time.Hour, "How often accounts due for deletion are purged, 0 to disable")
+	flag.DurationVar(&cfg.badges.backfillInterval, "badge-backfill-interval", 6*time.Hour, "How often badges are re-evaluated for every user who posted, 0 to disable")
+	flag.IntVar(&cfg.replies.maxDepth, "reply-max-depth", 8, "Deepest reply tree a client may ask for in one request")
 	flag.Parse()
 
+	if cfg.cors.trustedOrigins == nil {
+		cfg.cors.trustedOrigins = []string{"http://localhost:3000"}
+	}
+
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 
+	if cfg.replies.maxDepth < 1 {
+		logger.Fatal("-reply-max-depth must be at least 1")
+	}
+
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if keys.signing.ID == "ephemeral" {
+		logger.Println("No signing keys configured, using an ephemeral key; tokens will not survive a restart")
+	}
+
+	mail, err := newMailer(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	passwords, err := newPasswordPolicy(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Fatal(err)
@@ -68,10 +215,21 @@ func main() {
 	defer db.Close()
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: models.NewModels(db),
+		config:    cfg,
+		logger:    logger,
+		models:    models.NewModels(db),
+		keys:      keys,
+		mailer:    mail,
+		passwords: passwords,
+		pow:       newPowGuard(cfg),
+		oidc:      newOIDCProvider(cfg),
+		webauthn: &webauthnRelyingParty{
+			ID:      cfg.webauthn.rpID,
+			Name:    cfg.webauthn.rpName,
+			Origins: strings.Split(cfg.webauthn.origins, ","),
+		},
 	}
+	app.badges = &badges.Evaluator{DB: &app.models.DB}
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
@@ -81,6 +239,13 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	if cfg.deletion.purgeInterval > 0 {
+		go app.purgeDeletedAccounts(cfg.deletion.purgeInterval)
+	}
+	if cfg.badges.backfillInterval > 0 {
+		go app.backfillBadges(cfg.badges.backfillInterval)
+	}
+
 	logger.Println("Starting server on port", cfg.port)
 	err = srv.ListenAndServe()
 	if err != nil {
@@ -88,6 +253,34 @@ func main() {
 	}
 }
 
+func newMailer(cfg config) (mailer.Mailer, error) {
+	sender, err := mail.ParseAddress(cfg.mail.sender)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mail-sender %q: %w", cfg.mail.sender, err)
+	}
+
+	if cfg.mail.smtpHost != "" {
+		return &mailer.SMTP{
+			Host:     cfg.mail.smtpHost,
+			Port:     cfg.mail.smtpPort,
+			Username: cfg.mail.smtpUsername,
+			Password: cfg.mail.smtpPassword,
+			Sender:   sender.String(),
+			Envelope: sender.Address,
+		}, nil
+	}
+
+	if cfg.mail.file == "" {
+		return &mailer.Writer{W: os.Stdout, Sender: sender.String()}, nil
+	}
+
+	f, err := os.OpenFile(cfg.mail.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &mailer.Writer{W: f, Sender: sender.String()}, nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -104,3 +297,24 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// newPasswordPolicy hashes new passwords with the configured algorithm while
+// still accepting hashes made by the other one.
+func newPasswordPolicy(cfg config) (*hasher.Policy, error) {
+	bcryptHasher := &hasher.Bcrypt{Cost: cfg.passwords.bcryptCost}
+	argonHasher := &hasher.Argon2id{
+		Time:    uint32(cfg.passwords.argonTime),
+		Memory:  uint32(cfg.passwords.argonMemory),
+		Threads: uint8(cfg.passwords.argonThreads),
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+
+	switch cfg.passwords.hasher {
+	case "argon2id":
+		return &hasher.Policy{Current: argonHasher, Legacy: []hasher.Hasher{bcryptHasher}}, nil
+	case "bcrypt":
+		return &hasher.Policy{Current: bcryptHasher, Legacy: []hasher.Hasher{argonHasher}}, nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q", cfg.passwords.hasher)
+}
@@ -1,20 +1,40 @@
 package main
 
 import (
+    "backend/models"
+    "context"
     "net/http"
     "strings"
     "errors"
-    "github.com/pascaldekloe/jwt"
     "time"	
     "strconv"
-    "log"
+)
+
+type contextKey string
+
+const (
+    userIDContextKey    = contextKey("userID")
+    roleContextKey      = contextKey("role")
+    sessionIDContextKey = contextKey("sessionID")
+    scopesContextKey    = contextKey("scopes")
+
+    cookieSessionContextKey = contextKey("cookieSession")
 )
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+        // Cookie sessions only work for origins we trust with credentials;
+        // everyone else can still use bearer tokens.
+        origin := r.Header.Get("Origin")
+        w.Header().Add("Vary", "Origin")
+        if origin != "" && app.trustedOrigin(origin) {
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            w.Header().Set("Access-Control-Allow-Credentials", "true")
+        } else {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+        }
+        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,"+csrfHeader+","+powChallengeHeader+","+powNonceHeader)
 
         if r.Method == http.MethodOptions {
             return
@@ -26,59 +46,252 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 func (app *application) checkToken(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Vary", "Auhtorization")
+        w.Header().Add("Vary", "Authorization")
+        w.Header().Add("Vary", "Cookie")
 
         authHeader := r.Header.Get("Authorization")
 
+        var token string
+        fromCookie := false
+
         if authHeader == "" {
+            cookie, err := r.Cookie(sessionCookie)
+            if err != nil {
+                app.errorJSON(w, errors.New("unauthorized - no auth header"), http.StatusUnauthorized)
+                return
+            }
 
-        }
+            if !validCSRF(r) {
+                app.errorJSON(w, errors.New("forbidden - missing or invalid CSRF token"), http.StatusForbidden)
+                return
+            }
 
-        headerParts := strings.Split(authHeader, " ")
-        if len(headerParts) != 2 {
-            app.errorJSON(w, errors.New("invalid auth header"))
-            return
-        }
+            token = cookie.Value
+            fromCookie = true
+        } else {
+            headerParts := strings.Split(authHeader, " ")
+            if len(headerParts) != 2 {
+                app.errorJSON(w, errors.New("invalid auth header"))
+                return
+            }
 
-        if headerParts[0] != "Bearer" {
-            app.errorJSON(w, errors.New("unauthorized - no bearer"))
-            return
+            if headerParts[0] != "Bearer" {
+                app.errorJSON(w, errors.New("unauthorized - no bearer"))
+                return
+            }
+
+            token = headerParts[1]
+
+            if strings.HasPrefix(token, accessTokenPrefix) {
+                app.checkAccessToken(w, r, next, token)
+                return
+            }
         }
-        
-        token := headerParts[1]
 
-        claims, err := jwt.HMACCheck([]byte(token), []byte(app.config.jwt.secret))
+        claims, err := app.keys.check([]byte(token))
         if err != nil {
-            app.errorJSON(w, errors.New("unauthorized - failed hmac check"), http.StatusForbidden)
+            app.errorJSON(w, errors.New("unauthorized - failed signature check"), http.StatusForbidden)
             return
         }
 
         if !claims.Valid(time.Now()) {
-            app.errorJSON(w, errors.New("unauthorized - failed hmac check"), http.StatusForbidden)
+            app.errorJSON(w, errors.New("unauthorized - token expired"), http.StatusForbidden)
             return
         }
 
-        if !claims.AcceptAudience("testing") {
+        if len(claims.Audiences) == 0 || !claims.AcceptAudience(app.config.jwt.audience) {
             app.errorJSON(w, errors.New("invalid audience"), http.StatusForbidden)
             return
         }
 
-        if claims.Issuer != "testing" {
+        if claims.Issuer != app.config.jwt.issuer {
             app.errorJSON(w, errors.New("invalid issuer"), http.StatusForbidden)
             return
         }
 
-        userID, err := strconv.ParseInt(claims.Subject,10,64)
+        userID, err := strconv.Atoi(claims.Subject)
         if err != nil {
             app.errorJSON(w, errors.New("invalid subject"), http.StatusForbidden)
             return 
         }
 
-        log.Println("Valid user:", userID)
+        sid, _ := claims.String("sid")
+        sessionID, err := strconv.ParseInt(sid, 10, 64)
+        if err != nil {
+            app.errorJSON(w, errors.New("unauthorized - no session"), http.StatusUnauthorized)
+            return
+        }
+
+        active, err := app.models.DB.SessionActive(sessionID)
+        if err != nil || !active {
+            app.errorJSON(w, errors.New("unauthorized - session revoked"), http.StatusUnauthorized)
+            return
+        }
+
+        role, ok := claims.String("role")
+        if !ok || !models.ValidRole(role) {
+            role = models.RoleUser
+        }
+
+        ctx := context.WithValue(r.Context(), userIDContextKey, userID)
+        ctx = context.WithValue(ctx, roleContextKey, role)
+        ctx = context.WithValue(ctx, sessionIDContextKey, sessionID)
+        if fromCookie {
+            ctx = context.WithValue(ctx, cookieSessionContextKey, true)
+        }
+
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+// discardResponse swallows what checkToken writes when optionalToken
+// probes credentials.
+type discardResponse struct {
+    header http.Header
+}
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
+
+// optionalToken authenticates requests that carry credentials, like
+// checkToken. Anonymous requests, and those whose credentials fail, e.g.
+// an expired session cookie, go through unauthenticated.
+func (app *application) optionalToken(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Authorization")
+        w.Header().Add("Vary", "Cookie")
+
+        if r.Header.Get("Authorization") == "" {
+            if _, err := r.Cookie(sessionCookie); err != nil {
+                next.ServeHTTP(w, r)
+                return
+            }
+        }
+
+        authenticated := r
+        probe := app.checkToken(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+            authenticated = r
+        }))
+        probe.ServeHTTP(discardResponse{header: http.Header{}}, r)
+
+        next.ServeHTTP(w, authenticated)
+    })
+}
+
+// checkAccessToken authenticates a personal access token. The request gets
+// the owner's current effective role but only the scopes granted to the
+// token.
+func (app *application) checkAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+    pat, _, err := app.models.DB.GetAccessTokenByHash(hashToken(token))
+    if err != nil {
+        app.errorJSON(w, errors.New("unauthorized - invalid access token"), http.StatusUnauthorized)
+        return
+    }
+
+    user, err := app.models.DB.GetUserByID(pat.UserID)
+    if err != nil {
+        app.errorJSON(w, errors.New("unauthorized - invalid access token"), http.StatusUnauthorized)
+        return
+    }
+
+    // Staff who still have to enroll in two-factor sign-in act as plain
+    // users, as they do in sessions.
+    role, _ := app.sessionRole(user)
 
+    app.models.DB.TouchAccessToken(pat.ID)
 
+    ctx := context.WithValue(r.Context(), userIDContextKey, pat.UserID)
+    ctx = context.WithValue(ctx, roleContextKey, role)
+    ctx = context.WithValue(ctx, scopesContextKey, pat.Scopes)
+
+    next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// authenticatedUserID returns the user placed on the request by checkToken,
+// or 0 when the request did not pass through it.
+func (app *application) authenticatedUserID(r *http.Request) int {
+    userID, _ := r.Context().Value(userIDContextKey).(int)
+    return userID
+}
+
+func (app *application) authenticatedSessionID(r *http.Request) int64 {
+    sessionID, _ := r.Context().Value(sessionIDContextKey).(int64)
+    return sessionID
+}
+
+func (app *application) authenticatedRole(r *http.Request) string {
+    role, ok := r.Context().Value(roleContextKey).(string)
+    if !ok {
+        return models.RoleUser
+    }
+    return role
+}
 
+// requireRole rejects requests whose token does not carry at least role.
+// It must run after checkToken.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if !models.RoleAtLeast(app.authenticatedRole(r), role) {
+                app.errorJSON(w, errors.New("forbidden - requires "+role+" role"), http.StatusForbidden)
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// hasScope reports whether the request may act within scope. Only personal
+// access tokens are limited; signed in users hold every scope.
+func (app *application) hasScope(r *http.Request, scope string) bool {
+    scopes, ok := r.Context().Value(scopesContextKey).([]string)
+    if !ok {
+        return true
+    }
+    for _, s := range scopes {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// requireScope rejects personal access tokens lacking scope. It must run
+// after checkToken.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if !app.hasScope(r, scope) {
+                app.errorJSON(w, errors.New("forbidden - token lacks scope "+scope), http.StatusForbidden)
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// requireSession only lets through users who signed in, keeping personal
+// access tokens away from account management.
+func (app *application) requireSession(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if app.authenticatedSessionID(r) == 0 {
+            app.errorJSON(w, errors.New("forbidden - sign in to manage your account"), http.StatusForbidden)
+            return
+        }
 
         next.ServeHTTP(w, r)
     })
-}
\ No newline at end of file
+}
+
+// canModify reports whether the authenticated user may change content
+// written by authorID.
+func (app *application) canModify(r *http.Request, authorID int) bool {
+    userID := app.authenticatedUserID(r)
+    if userID == 0 {
+        return false
+    }
+    return userID == authorID || models.RoleAtLeast(app.authenticatedRole(r), models.RoleModerator)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"backend/models"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+const (
+	oidcLoginCookie  = "oidc_login"
+	oidcLoginTTL     = 10 * time.Minute
+	oidcDiscoveryTTL = time.Hour
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider talks to the configured OpenID Connect issuer. Discovery and
+// keys are fetched lazily and cached; an unknown key ID triggers a refetch
+// so key rotation at the provider is picked up.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      *jwt.KeyRegister
+	fetchedAt time.Time
+}
+
+func newOIDCProvider(cfg config) *oidcProvider {
+	if cfg.oidc.issuer == "" {
+		return nil
+	}
+
+	return &oidcProvider{
+		issuer:       strings.TrimSuffix(cfg.oidc.issuer, "/"),
+		clientID:     cfg.oidc.clientID,
+		clientSecret: cfg.oidc.clientSecret,
+		redirectURL:  cfg.oidc.redirectURL,
+		scopes:       cfg.oidc.scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *oidcProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// load returns the discovery document and keys, refreshing them when stale
+// or when force is set.
+func (p *oidcProvider) load(force bool) (*oidcDiscovery, *jwt.KeyRegister, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && !force && time.Since(p.fetchedAt) < oidcDiscoveryTTL {
+		return p.discovery, p.keys, nil
+	}
+
+	var d oidcDiscovery
+	err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, nil, fmt.Errorf("discovery document is for issuer %q", d.Issuer)
+	}
+
+	var raw json.RawMessage
+	err = p.getJSON(d.JWKSURI, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := new(jwt.KeyRegister)
+	_, err = keys.LoadJWK(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.discovery, p.keys, p.fetchedAt = &d, keys, time.Now()
+	return p.discovery, p.keys, nil
+}
+
+type oidcIDClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// exchange redeems an authorization code and returns the verified claims of
+// the ID token.
+func (p *oidcProvider) exchange(code, verifier, nonce string) (*oidcIDClaims, error) {
+	d, _, err := p.load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response without id_token")
+	}
+
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+func (p *oidcProvider) verifyIDToken(token, nonce string) (*oidcIDClaims, error) {
+	_, keys, err := p.load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := keys.Check([]byte(token))
+	if errors.Is(err, jwt.ErrSigMiss) {
+		_, keys, err = p.load(true)
+		if err == nil {
+			claims, err = keys.Check([]byte(token))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	if claims.Issuer != p.issuer && claims.Issuer != p.issuer+"/" {
+		return nil, errors.New("id token: wrong issuer")
+	}
+	if claims.Expires == nil || claims.AcceptTemporal(time.Now(), time.Minute) != nil {
+		return nil, errors.New("id token: expired")
+	}
+	if len(claims.Audiences) == 0 || !claims.AcceptAudience(p.clientID) {
+		return nil, errors.New("id token: wrong audience")
+	}
+	if azp, ok := claims.String("azp"); ok && azp != p.clientID {
+		return nil, errors.New("id token: wrong authorized party")
+	}
+	if got, _ := claims.String("nonce"); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("id token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token: no subject")
+	}
+
+	id := &oidcIDClaims{Subject: claims.Subject}
+	id.Email, _ = claims.String("email")
+	id.PreferredUsername, _ = claims.String("preferred_username")
+	id.Name, _ = claims.String("name")
+	id.EmailVerified, _ = claims.Set["email_verified"].(bool)
+
+	return id, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oidcLogin starts the authorization code flow. The PKCE verifier, state and
+// nonce travel in a signed, HttpOnly cookie rather than server memory.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	d, _, err := app.oidc.load(false)
+	if err != nil {
+		app.logger.Println("oidc discovery:", err)
+		app.errorJSON(w, errors.New("identity provider unavailable"), http.StatusBadGateway)
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secrets[i], _, err = newTokenSecret()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	cookie, err := app.signPurposeToken("oidc-login", "", map[string]interface{}{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"cookie":   app.wantsCookieSession(r),
+		"invite":   r.URL.Query().Get("invite_code"),
+	}, oidcLoginTTL)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    cookie,
+		Path:     "/v1/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.env != "development",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {app.oidc.clientID},
+		"redirect_uri":          {app.oidc.redirectURL},
+		"scope":                 {app.oidc.scopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign-on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcLoginCookie, Path: "/v1/oidc", MaxAge: -1})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		app.errorJSON(w, fmt.Errorf("sign-in was not completed: %s", e), http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		app.errorJSON(w, errors.New("sign-in session missing or expired"), http.StatusUnauthorized)
+		return
+	}
+
+	login, err := app.checkPurposeToken(cookie.Value, "oidc-login")
+	if err != nil {
+		app.errorJSON(w, errors.New("sign-in session missing or expired"), http.StatusUnauthorized)
+		return
+	}
+
+	state, _ := login.String("state")
+	nonce, _ := login.String("nonce")
+	verifier, _ := login.String("verifier")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		app.errorJSON(w, errors.New("state mismatch"), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := app.oidc.exchange(q.Get("code"), verifier, nonce)
+	if err != nil {
+		app.logger.Println("oidc:", err)
+		app.errorJSON(w, errors.New("sign-in with the identity provider failed"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.models.DB.GetUserByIdentity(app.oidc.issuer, id.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = app.oidcSignUp(id, login)
+		if err != nil {
+			app.refuseSignup(w, err)
+			return
+		}
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// The callback URL is fixed, so the session mode chosen when signing
+	// in travels in the login cookie.
+	if cookieSession, _ := login.Set["cookie"].(bool); cookieSession {
+		r = withCookieSession(r)
+	}
+
+	// The identity provider is the first factor only.
+	app.finishSignIn(w, r, user)
+}
+
+// oidcSignUp creates the account for an identity seen for the first time,
+// subject to the registration mode. Only a verified email counts towards a
+// domain allowlist.
+func (app *application) oidcSignUp(id *oidcIDClaims, login *jwt.Claims) (*models.User, error) {
+	email := ""
+	if id.EmailVerified {
+		email = id.Email
+	}
+	inviteCode, _ := login.String("invite")
+
+	invitationID, err := app.admitSignup(email, inviteCode, true)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username: oidcUsername(id),
+		Email:    id.Email,
+	}
+	err = app.models.DB.InsertUserWithIdentity(user, app.oidc.issuer, id.Subject, id.EmailVerified)
+	if err != nil {
+		app.abandonSignup(invitationID)
+		return nil, err
+	}
+
+	app.finishSignup(invitationID, user.UserID)
+	return user, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oidcUsername picks the username for an account created on first sign-in.
+func oidcUsername(id *oidcIDClaims) string {
+	candidates := []string{id.PreferredUsername}
+	if local, _, found := strings.Cut(id.Email, "@"); found {
+		candidates = append(candidates, local)
+	}
+	candidates = append(candidates, id.Name)
+
+	for _, c := range candidates {
+		c = usernameUnsafe.ReplaceAllString(c, "")
+		if len(c) > 30 {
+			c = c[:30]
+		}
+		if c != "" {
+			return c
+		}
+	}
+	return "student"
+}
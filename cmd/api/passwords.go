@@ -0,0 +1,52 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed data/common-passwords.txt
+var commonPasswordList string
+
+var commonPasswords = parseWordList(commonPasswordList)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes.
+	maxPasswordLength = 72
+)
+
+func parseWordList(list string) map[string]bool {
+	words := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words[strings.ToLower(line)] = true
+		}
+	}
+	return words
+}
+
+// validatePassword enforces the password policy for new passwords.
+func validatePassword(username, password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("password is too common")
+	}
+
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+
+	return nil
+}
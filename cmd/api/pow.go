@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Signups, and optionally threads from brand-new accounts, must carry the
+// solution to a hashcash-style puzzle: find a nonce such that
+// SHA-256(challenge || nonce) starts with difficulty zero bits. Challenges
+// are signed, so nothing is stored until one is spent; difficulty goes up
+// one bit, doubling the expected work, each time the signup rate doubles
+// past the configured threshold.
+const (
+	powChallengeTTL = 5 * time.Minute
+	powMaxNonce     = 64
+
+	powChallengeHeader = "X-PoW-Challenge"
+	powNonceHeader     = "X-PoW-Nonce"
+
+	powActionSignup = "signup"
+	powActionThread = "thread"
+)
+
+type powGuard struct {
+	base   int
+	max    int
+	rate   int
+	window time.Duration
+
+	mu        sync.Mutex
+	spent     map[string]time.Time // challenge ID -> expiry
+	signups   []time.Time
+	lastPrune time.Time
+}
+
+func newPowGuard(cfg config) *powGuard {
+	return &powGuard{
+		base:   cfg.pow.difficulty,
+		max:    cfg.pow.maxDifficulty,
+		rate:   cfg.pow.signupRate,
+		window: cfg.pow.window,
+		spent:  map[string]time.Time{},
+	}
+}
+
+// difficulty returns the number of leading zero bits to ask for now.
+func (g *powGuard) difficulty(action string, now time.Time) int {
+	if action != powActionSignup || g.rate <= 0 {
+		return g.base
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune(now)
+
+	d := g.base
+	for n := g.rate; len(g.signups) >= n && d < g.max; n *= 2 {
+		d++
+	}
+	return d
+}
+
+// spend marks a challenge as used and reports whether it was still unused.
+func (g *powGuard) spend(id, action string, expires, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune(now)
+
+	if _, used := g.spent[id]; used {
+		return false
+	}
+	g.spent[id] = expires
+
+	if action == powActionSignup {
+		g.signups = append(g.signups, now)
+	}
+	return true
+}
+
+// prune forgets expired challenges and signups that left the window. The
+// caller holds g.mu.
+func (g *powGuard) prune(now time.Time) {
+	i := 0
+	for i < len(g.signups) && now.Sub(g.signups[i]) > g.window {
+		i++
+	}
+	g.signups = g.signups[i:]
+
+	if now.Sub(g.lastPrune) < time.Minute {
+		return
+	}
+	g.lastPrune = now
+	for id, expires := range g.spent {
+		if now.After(expires) {
+			delete(g.spent, id)
+		}
+	}
+}
+
+func leadingZeroBits(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// powChallenge hands out a puzzle for the action given in the query string.
+func (app *application) powChallenge(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("action")
+	if action == "" {
+		action = powActionSignup
+	}
+	if action != powActionSignup && action != powActionThread {
+		app.errorJSON(w, errors.New("unknown action"))
+		return
+	}
+
+	id, _, err := newTokenSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	difficulty := app.pow.difficulty(action, now)
+
+	challenge, err := app.signPurposeToken("pow", "", map[string]interface{}{
+		"id":         id,
+		"action":     action,
+		"difficulty": difficulty,
+	}, powChallengeTTL)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"challenge":  challenge,
+		"algorithm":  "sha256",
+		"difficulty": difficulty,
+		"expires_at": now.Add(powChallengeTTL),
+	}
+
+	app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+// checkProofOfWork verifies and spends the solution sent with r.
+func (app *application) checkProofOfWork(r *http.Request, action string) error {
+	challenge := r.Header.Get(powChallengeHeader)
+	nonce := r.Header.Get(powNonceHeader)
+	if challenge == "" || nonce == "" {
+		return errors.New("proof of work required")
+	}
+	if len(nonce) > powMaxNonce {
+		return errors.New("proof of work nonce too long")
+	}
+
+	claims, err := app.checkPurposeToken(challenge, "pow")
+	if err != nil {
+		return errors.New("invalid or expired proof of work challenge")
+	}
+
+	id, _ := claims.String("id")
+	issuedFor, _ := claims.String("action")
+	difficulty, _ := claims.Number("difficulty")
+	if id == "" || issuedFor != action {
+		return errors.New("proof of work challenge is for another action")
+	}
+
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	if leadingZeroBits(sum[:]) < int(difficulty) {
+		return errors.New("proof of work solution is wrong")
+	}
+
+	if !app.pow.spend(id, action, claims.Expires.Time(), time.Now()) {
+		return errors.New("proof of work challenge already used")
+	}
+
+	return nil
+}
+
+// requireSignupWork guards the routes that create accounts.
+func (app *application) requireSignupWork(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := app.checkProofOfWork(r, powActionSignup)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusPreconditionRequired)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireNewAccountWork makes accounts younger than the configured age
+// solve a puzzle per thread. It must run after checkToken.
+func (app *application) requireNewAccountWork(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.pow.newAccountAge <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		if time.Since(user.CreatedAt) < app.config.pow.newAccountAge {
+			err = app.checkProofOfWork(r, powActionThread)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusPreconditionRequired)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
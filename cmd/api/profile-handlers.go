@@ -0,0 +1,218 @@
+package main
+
+import (
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxDisplayNameLength = 100
+	maxBioLength         = 1000
+	maxAvatarURLLength   = 500
+)
+
+// Usernames picked when renaming must fit in URLs and mentions. Older
+// accounts may have names outside this pattern and keep them.
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,30}$`)
+
+func (app *application) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	profile, err := app.models.DB.GetProfile(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, profile, "profile")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// MeResponse is the caller's own profile plus what only they may see.
+type MeResponse struct {
+	*models.Profile
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+
+	PendingDeletion *models.AccountDeletion `json:"pending_deletion,omitempty"`
+}
+
+func (app *application) writeMe(w http.ResponseWriter, userID int) {
+	user, err := app.models.DB.GetUserByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	profile, err := app.models.DB.GetProfile(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	me := MeResponse{
+		Profile:       profile,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		TOTPEnabled:   user.TOTPEnabled,
+	}
+
+	deletion, err := app.models.DB.GetAccountDeletion(userID)
+	if err == nil {
+		me.PendingDeletion = deletion
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, me, "me")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getMe(w http.ResponseWriter, r *http.Request) {
+	app.writeMe(w, app.authenticatedUserID(r))
+}
+
+// UpdateMePayload holds the fields to change; omitted fields stay as they
+// are.
+type UpdateMePayload struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+func (app *application) updateMe(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateMePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	profile, err := app.models.DB.GetProfile(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*payload.DisplayName)
+		if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+			app.errorJSON(w, errors.New("display_name must be at most "+strconv.Itoa(maxDisplayNameLength)+" characters"))
+			return
+		}
+	}
+
+	if payload.Bio != nil {
+		profile.Bio = strings.TrimSpace(*payload.Bio)
+		if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+			app.errorJSON(w, errors.New("bio must be at most "+strconv.Itoa(maxBioLength)+" characters"))
+			return
+		}
+	}
+
+	if payload.AvatarURL != nil {
+		profile.AvatarURL = strings.TrimSpace(*payload.AvatarURL)
+		if profile.AvatarURL != "" && !validAvatarURL(profile.AvatarURL) {
+			app.errorJSON(w, errors.New("avatar_url must be an http or https URL"))
+			return
+		}
+	}
+
+	if payload.Username != nil {
+		username := strings.TrimSpace(*payload.Username)
+		if username != user.Username {
+			if !validUsername.MatchString(username) {
+				app.errorJSON(w, errors.New("username must be 3 to 30 letters, digits, dots, dashes or underscores"))
+				return
+			}
+
+			err = app.models.DB.SetUsername(user.UserID, username)
+			if errors.Is(err, models.ErrDuplicate) {
+				app.errorJSON(w, errors.New("username is taken"), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				app.errorJSON(w, err)
+				return
+			}
+		}
+	}
+
+	err = app.models.DB.UpdateProfile(user.UserID, profile.DisplayName, profile.Bio, profile.AvatarURL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeMe(w, user.UserID)
+}
+
+func validAvatarURL(s string) bool {
+	if len(s) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// getMyReputation lists how the caller's reputation came about, newest
+// first, e.g. ?limit=50.
+func (app *application) getMyReputation(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			app.errorJSON(w, errors.New("limit must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+
+	events, err := app.models.DB.ReputationHistory(app.authenticatedUserID(r), limit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, events, "events")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
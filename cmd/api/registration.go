@@ -0,0 +1,348 @@
+package main
+
+import (
+	"backend/models"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Registration modes, stored in the registration site setting.
+const (
+	// RegistrationOpen lets anyone sign up.
+	RegistrationOpen = "open"
+	// RegistrationInvite requires an invitation code.
+	RegistrationInvite = "invite"
+	// RegistrationDomain requires an email address at one of the allowed
+	// domains, or an invitation code. As a typed address proves nothing,
+	// it implies -require-verified-email.
+	RegistrationDomain = "domain"
+)
+
+const (
+	defaultInvitationDays = 14
+	maxInvitationDays     = 365
+	maxInvitationUses     = 10000
+)
+
+var (
+	errSignupForbidden         = errors.New("registration is closed")
+	errRegistrationUnavailable = errors.New("registration settings are unavailable, try again later")
+)
+
+type RegistrationSettings struct {
+	Mode           string   `json:"mode"`
+	AllowedDomains []string `json:"allowed_domains"`
+}
+
+// registrationSettings returns the registration setting, open when none
+// was saved. A setting that cannot be read is an error rather than open,
+// so a database problem does not open up invite or domain only sign-up.
+func (app *application) registrationSettings() (RegistrationSettings, error) {
+	settings := RegistrationSettings{Mode: RegistrationOpen, AllowedDomains: []string{}}
+	_, err := app.models.DB.GetSiteSetting(models.SiteSettingRegistration, &settings)
+	if err != nil {
+		app.logger.Println(err)
+		return settings, errRegistrationUnavailable
+	}
+	return settings, nil
+}
+
+// emailVerificationRequired reports whether users need a verified email
+// address before they may post or vote. When in doubt they do.
+func (app *application) emailVerificationRequired() bool {
+	if app.config.mail.requireVerified {
+		return true
+	}
+	settings, err := app.registrationSettings()
+	return err != nil || settings.Mode == RegistrationDomain
+}
+
+// allowedEmailDomain reports whether email is at one of domains or any of
+// their subdomains.
+func allowedEmailDomain(email string, domains []string) bool {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return false
+	}
+	host := strings.ToLower(email[at+1:])
+
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+var invitationEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newInvitationCode returns a code people can type, as XXXX-XXXX-XXXX-XXXX.
+func newInvitationCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	s := invitationEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// hashInvitationCode ignores case and separators, as codes get retyped.
+func hashInvitationCode(code string) []byte {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// admitSignup decides whether someone with email and inviteCode may create
+// an account under the current registration mode. With redeem set, a valid
+// invitation is used up and its ID returned; the caller then passes it to
+// finishSignup once the account exists, or to abandonSignup if it could not
+// be created.
+func (app *application) admitSignup(email, inviteCode string, redeem bool) (int64, error) {
+	if inviteCode != "" {
+		hash := hashInvitationCode(inviteCode)
+
+		if !redeem {
+			valid, err := app.models.DB.InvitationValid(hash)
+			if err != nil {
+				return 0, err
+			}
+			if !valid {
+				return 0, errors.New("invalid or expired invitation code")
+			}
+			return 0, nil
+		}
+
+		id, err := app.models.DB.RedeemInvitation(hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.New("invalid or expired invitation code")
+		}
+		return id, err
+	}
+
+	settings, err := app.registrationSettings()
+	if err != nil {
+		return 0, err
+	}
+
+	switch settings.Mode {
+	case RegistrationOpen:
+		return 0, nil
+	case RegistrationInvite:
+		return 0, errors.New("registration requires an invitation code")
+	case RegistrationDomain:
+		if email == "" || !allowedEmailDomain(email, settings.AllowedDomains) {
+			return 0, errors.New("registration is limited to email addresses at " + strings.Join(settings.AllowedDomains, ", ") + ", or requires an invitation code")
+		}
+		return 0, nil
+	}
+
+	return 0, errSignupForbidden
+}
+
+// refuseSignup answers an error of admitSignup: a server error when the
+// registration settings could not be read, forbidden otherwise.
+func (app *application) refuseSignup(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRegistrationUnavailable) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.errorJSON(w, err, http.StatusForbidden)
+}
+
+func (app *application) finishSignup(invitationID int64, userID int) {
+	if invitationID == 0 {
+		return
+	}
+	err := app.models.DB.SetUserInvitation(userID, invitationID)
+	if err != nil {
+		app.logger.Println(err)
+	}
+}
+
+func (app *application) abandonSignup(invitationID int64) {
+	if invitationID == 0 {
+		return
+	}
+	err := app.models.DB.ReleaseInvitation(invitationID)
+	if err != nil {
+		app.logger.Println(err)
+	}
+}
+
+// getRegistration tells the signup form which fields it needs. The domain
+// list is public anyway through the error signUp gives.
+func (app *application) getRegistration(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.registrationSettings()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, settings, "registration")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) updateRegistration(w http.ResponseWriter, r *http.Request) {
+	var payload RegistrationSettings
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	switch payload.Mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationDomain:
+	default:
+		app.errorJSON(w, errors.New("mode must be open, invite or domain"))
+		return
+	}
+
+	domains := []string{}
+	for _, domain := range payload.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" || strings.ContainsAny(domain, "@ /") || !strings.Contains(domain, ".") {
+			app.errorJSON(w, errors.New("invalid domain "+strconv.Quote(domain)))
+			return
+		}
+		domains = append(domains, domain)
+	}
+	if payload.Mode == RegistrationDomain && len(domains) == 0 {
+		app.errorJSON(w, errors.New("domain mode needs at least one allowed domain"))
+		return
+	}
+	payload.AllowedDomains = domains
+
+	err = app.models.DB.SetSiteSetting(models.SiteSettingRegistration, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload, "registration")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+type InvitationPayload struct {
+	Note          string `json:"note"`
+	MaxUses       int    `json:"max_uses"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+func (app *application) createInvitation(w http.ResponseWriter, r *http.Request) {
+	var payload InvitationPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	payload.Note = strings.TrimSpace(payload.Note)
+	if len(payload.Note) > 200 {
+		app.errorJSON(w, errors.New("note must be at most 200 characters"))
+		return
+	}
+
+	if payload.MaxUses == 0 {
+		payload.MaxUses = 1
+	}
+	if payload.MaxUses < 1 || payload.MaxUses > maxInvitationUses {
+		app.errorJSON(w, errors.New("max_uses must be between 1 and "+strconv.Itoa(maxInvitationUses)))
+		return
+	}
+
+	if payload.ExpiresInDays == 0 {
+		payload.ExpiresInDays = defaultInvitationDays
+	}
+	if payload.ExpiresInDays < 1 || payload.ExpiresInDays > maxInvitationDays {
+		app.errorJSON(w, errors.New("expires_in_days must be between 1 and "+strconv.Itoa(maxInvitationDays)))
+		return
+	}
+
+	code, err := newInvitationCode()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	expires := time.Now().AddDate(0, 0, payload.ExpiresInDays)
+	inv := &models.Invitation{
+		CodeHash:  hashInvitationCode(code),
+		Note:      payload.Note,
+		MaxUses:   payload.MaxUses,
+		CreatedBy: app.authenticatedUserID(r),
+		ExpiresAt: &expires,
+	}
+
+	err = app.models.DB.InsertInvitation(inv)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The only time the code is shown.
+	inv.Code = code
+
+	err = app.writeJSON(w, http.StatusCreated, inv, "invitation")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) listInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.models.DB.Invitations()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, invitations, "invitations")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.RevokeInvitation(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("invitation not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok := jsonResponse{OK: true}
+
+	app.writeJSON(w, http.StatusOK, ok, "response")
+}
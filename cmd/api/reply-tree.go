@@ -0,0 +1,222 @@
+package main
+
+import (
+	"backend/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultReplyDepth    = 3
+	defaultReplyChildren = 20
+	maxReplyChildren     = 100
+)
+
+type replyOptions struct {
+	sort  string
+	depth int
+	limit int
+}
+
+// replyTreeOptions reads ?sort=, ?depth= (levels of replies to return) and
+// ?limit= (children per reply) from r.
+func (app *application) replyTreeOptions(r *http.Request) (replyOptions, error) {
+	query := r.URL.Query()
+	opts := replyOptions{
+		sort:  query.Get("sort"),
+		depth: defaultReplyDepth,
+		limit: defaultReplyChildren,
+	}
+
+	if opts.sort != "" && !contains(models.ReplySorts, opts.sort) {
+		return opts, errors.New("sort must be one of " + strings.Join(models.ReplySorts, ", "))
+	}
+
+	if opts.depth > app.config.replies.maxDepth {
+		opts.depth = app.config.replies.maxDepth
+	}
+	if v := query.Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > app.config.replies.maxDepth {
+			return opts, fmt.Errorf("depth must be between 1 and %d", app.config.replies.maxDepth)
+		}
+		opts.depth = n
+	}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReplyChildren {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxReplyChildren)
+		}
+		opts.limit = n
+	}
+
+	return opts, nil
+}
+
+// threadReplies loads every reply of a thread in display order, with the
+// caller's votes.
+func (app *application) threadReplies(r *http.Request, threadID int, sort string) ([]*models.Reply, error) {
+	replies, err := app.models.DB.GetReplies(threadID, sort)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(replies))
+	for i, reply := range replies {
+		ids[i] = reply.ID
+	}
+	votes, err := app.models.DB.UserVotes(app.authenticatedUserID(r), models.VoteReply, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, reply := range replies {
+		reply.MyVote = votes[reply.ID]
+	}
+
+	return replies, nil
+}
+
+// replyTree links replies, given in display order, into trees and returns
+// the roots along with every reply by ID. Replies whose parent is missing
+// count as roots.
+func replyTree(replies []*models.Reply) ([]*models.Reply, map[int]*models.Reply) {
+	byID := make(map[int]*models.Reply, len(replies))
+	for _, reply := range replies {
+		reply.Children = nil
+		byID[reply.ID] = reply
+	}
+
+	var roots []*models.Reply
+	for _, reply := range replies {
+		if reply.ParentID != nil {
+			if parent, ok := byID[*reply.ParentID]; ok {
+				parent.Children = append(parent.Children, reply)
+				continue
+			}
+		}
+		roots = append(roots, reply)
+	}
+
+	for _, reply := range replies {
+		reply.ChildCount = len(reply.Children)
+	}
+
+	return roots, byID
+}
+
+// pruneReplies cuts the trees below replies down to depth levels, counting
+// replies themselves as the first, with at most limit children each.
+func pruneReplies(replies []*models.Reply, depth, limit int) {
+	for _, reply := range replies {
+		pageChildren(reply, 0, depth-1, limit)
+	}
+}
+
+// pageChildren keeps limit of the reply's children starting at offset,
+// pruned to depth levels, and sets the cursor for loading the ones after.
+func pageChildren(reply *models.Reply, offset, depth, limit int) {
+	all := reply.Children
+	reply.Children = nil
+	reply.MoreCursor = ""
+
+	if depth < 1 {
+		if len(all) > 0 {
+			reply.MoreCursor = encodeReplyCursor(0)
+		}
+		return
+	}
+
+	if offset > len(all) {
+		offset = len(all)
+	}
+	end := offset + limit
+	if end > len(all) {
+		end = len(all)
+	}
+
+	reply.Children = all[offset:end]
+	if end < len(all) {
+		reply.MoreCursor = encodeReplyCursor(end)
+	}
+
+	pruneReplies(reply.Children, depth, limit)
+}
+
+// Cursors are opaque to clients; they hold the offset into a reply's
+// children.
+func encodeReplyCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeReplyCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return offset, nil
+}
+
+// getReplyChildren loads more children of a reply, e.g.
+// ?cursor=<more_cursor>&depth=2. It answers with the reply itself, its
+// children set to the requested page and depth counted from them.
+func (app *application) getReplyChildren(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts, err := app.replyTreeOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	offset := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		offset, err = decodeReplyCursor(cursor)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	parent, err := app.models.DB.GetReply(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+
+	replies, err := app.threadReplies(r, parent.ThreadID, opts.sort)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, byID := replyTree(replies)
+	reply, ok := byID[id]
+	if !ok {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+	pageChildren(reply, offset, opts.depth, opts.limit)
+
+	err = app.writeJSON(w, http.StatusOK, reply, "reply")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backend/models"
 	"context"
 	"net/http"
 
@@ -10,7 +11,7 @@ import (
 
 func (app *application) wrap(next http.Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		ctx := context.WithValue(r.Context(), "params", ps)
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, ps)
 		next.ServeHTTP(w, r.WithContext(ctx))
 
 	}
@@ -20,37 +21,116 @@ func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	secure := alice.New(app.checkToken)
+	optional := alice.New(app.optionalToken)
+	session := secure.Append(app.requireSession)
+	reader := secure.Append(app.requireScope(models.AccessScopeRead))
+	threadWriter := secure.Append(app.requireScope(models.AccessScopeWriteThreads))
+	replyWriter := secure.Append(app.requireScope(models.AccessScopeWriteReplies))
+	moderator := secure.Append(app.requireScope(models.AccessScopeAdmin), app.requireRole(models.RoleModerator))
+	admin := secure.Append(app.requireScope(models.AccessScopeAdmin), app.requireRole(models.RoleAdmin))
 
-	router.HandlerFunc(http.MethodPost, "/v1/signup", app.signUp)
+	router.HandlerFunc(http.MethodGet, "/v1/pow/challenge", app.powChallenge)
+	router.HandlerFunc(http.MethodGet, "/v1/registration", app.getRegistration)
+	router.HandlerFunc(http.MethodPost, "/v1/signup", app.requireSignupWork(app.signUp))
     router.HandlerFunc(http.MethodPost, "/v1/signin", app.signIn)
+	router.HandlerFunc(http.MethodPost, "/v1/signin/2fa", app.signInTOTP)
+	router.HandlerFunc(http.MethodPost, "/v1/token/refresh", app.refreshToken)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLogin)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallback)
+	router.POST("/v1/signout", app.wrap(session.ThenFunc(app.signOut)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/password/forgot", app.forgotPassword)
+	router.HandlerFunc(http.MethodPost, "/v1/password/reset", app.resetPassword)
+	router.HandlerFunc(http.MethodPost, "/v1/email/verify", app.verifyEmail)
+	router.HandlerFunc(http.MethodPost, "/v1/account/activate", app.activateAccount)
+	router.POST("/v1/email/resend", app.wrap(session.ThenFunc(app.resendVerification)))
+
+	router.GET("/v1/sessions", app.wrap(session.ThenFunc(app.listSessions)))
+	router.DELETE("/v1/sessions/:id", app.wrap(session.ThenFunc(app.revokeSession)))
+
+	router.POST("/v1/2fa/totp/enroll", app.wrap(session.ThenFunc(app.enrollTOTP)))
+	router.POST("/v1/2fa/totp/confirm", app.wrap(session.ThenFunc(app.confirmTOTP)))
+	router.POST("/v1/2fa/totp/disable", app.wrap(session.ThenFunc(app.disableTOTP)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys/signup/begin", app.beginPasskeySignUp)
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys/signup/finish", app.requireSignupWork(app.finishPasskeySignUp))
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys/login/begin", app.beginPasskeyLogin)
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys/login/finish", app.finishPasskeyLogin)
+	router.POST("/v1/passkeys/register/begin", app.wrap(session.ThenFunc(app.beginPasskeyRegistration)))
+	router.POST("/v1/passkeys/register/finish", app.wrap(session.ThenFunc(app.finishPasskeyRegistration)))
+	router.GET("/v1/passkeys", app.wrap(session.ThenFunc(app.listPasskeys)))
+	router.DELETE("/v1/passkeys/:id", app.wrap(session.ThenFunc(app.deletePasskey)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.getUserProfile)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/badges", app.getUserBadges)
+	router.HandlerFunc(http.MethodGet, "/v1/badges", app.listBadges)
+	router.HandlerFunc(http.MethodGet, "/v1/badges/:id", app.getBadgeHolders)
+	router.GET("/v1/me", app.wrap(reader.ThenFunc(app.getMe)))
+	router.PATCH("/v1/me", app.wrap(session.ThenFunc(app.updateMe)))
+	router.GET("/v1/me/reputation", app.wrap(reader.ThenFunc(app.getMyReputation)))
+	router.GET("/v1/me/settings", app.wrap(reader.ThenFunc(app.getSettings)))
+	router.PATCH("/v1/me/settings", app.wrap(session.ThenFunc(app.updateSettings)))
+	router.GET("/v1/me/export", app.wrap(session.ThenFunc(app.exportAccount)))
+	router.POST("/v1/me/deletion", app.wrap(session.ThenFunc(app.requestAccountDeletion)))
+	router.DELETE("/v1/me/deletion", app.wrap(session.ThenFunc(app.cancelAccountDeletion)))
+
+	router.POST("/v1/tokens", app.wrap(session.ThenFunc(app.createAccessToken)))
+	router.GET("/v1/tokens", app.wrap(session.ThenFunc(app.listAccessTokens)))
+	router.DELETE("/v1/tokens/:id", app.wrap(session.ThenFunc(app.revokeAccessToken)))
 
 	router.HandlerFunc(http.MethodGet, "/status", app.statusHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwks)
 
 
-	router.HandlerFunc(http.MethodGet, "/v1/thread/:id", app.getOneThread)
-	router.HandlerFunc(http.MethodGet, "/v1/threads", app.getAllThreads)
-	router.HandlerFunc(http.MethodGet, "/v1/threads/:category_id", app.getAllThreadsByCategory)
+	router.GET("/v1/thread/:id", app.wrap(optional.ThenFunc(app.getOneThread)))
+	router.GET("/v1/threads", app.wrap(optional.ThenFunc(app.getAllThreads)))
+	router.GET("/v1/threads/:category_id", app.wrap(optional.ThenFunc(app.getAllThreadsByCategory)))
 	router.HandlerFunc(http.MethodGet, "/v1/categories", app.getAllCategories)
-	router.HandlerFunc(http.MethodGet, "/v1/replies/:thread_id", app.getReplies)
+	router.GET("/v1/replies/:thread_id", app.wrap(optional.ThenFunc(app.getReplies)))
+	router.GET("/v1/reply/:id/children", app.wrap(optional.ThenFunc(app.getReplyChildren)))
+	router.GET("/v1/reply/:id/revisions", app.wrap(reader.ThenFunc(app.getReplyRevisions)))
 
-	router.POST("/v1/admin/editthread", app.wrap(secure.ThenFunc(app.editThread)))
+	router.POST("/v1/admin/editthread", app.wrap(moderator.ThenFunc(app.editThread)))
 	//router.HandlerFunc(http.MethodPost, "/v1/admin/editthread", app.editThread)
 
-	router.GET("/v1/admin/deletethread/:id", app.wrap(secure.ThenFunc(app.deleteThread)))
+	router.GET("/v1/admin/deletethread/:id", app.wrap(moderator.Append(app.requireCSRF).ThenFunc(app.deleteThread)))
 	//router.HandlerFunc(http.MethodGet, "/v1/admin/deletethread/:id", app.deleteThread)
 
-	router.HandlerFunc(http.MethodPost, "/v1/newreply/:thread_id", app.newReply)
-	router.HandlerFunc(http.MethodGet, "/v1/deletereply/:id", app.deleteReply)
-	router.HandlerFunc(http.MethodPost, "/v1/newthread", app.newThread)
-	router.HandlerFunc(http.MethodPost, "/v1/editthread/", app.editThread)
-	router.HandlerFunc(http.MethodPut, "/v1/togglesolved/:id", app.toggleSolved)
-	router.HandlerFunc(http.MethodPut, "/v1/toggleanswer/:reply_id", app.toggleAnswer)
-	router.HandlerFunc(http.MethodGet, "/v1/deletethread/:id", app.deleteThread)
-	router.HandlerFunc(http.MethodGet, "/v1/yourthreads/:author_id", app.yourThreads)
+	router.GET("/v1/admin/users", app.wrap(admin.ThenFunc(app.listUsers)))
+	router.GET("/v1/admin/users/:id", app.wrap(admin.ThenFunc(app.getUserDetails)))
+	router.POST("/v1/admin/users/:id/signout", app.wrap(admin.ThenFunc(app.forceSignOut)))
+	router.POST("/v1/admin/users/:id/password-reset", app.wrap(admin.ThenFunc(app.triggerPasswordReset)))
+	router.PUT("/v1/admin/users/:id/disabled", app.wrap(admin.ThenFunc(app.disableUser)))
+	router.DELETE("/v1/admin/users/:id/disabled", app.wrap(admin.ThenFunc(app.enableUser)))
+	router.PUT("/v1/admin/users/:id/role", app.wrap(admin.ThenFunc(app.grantRole)))
+	router.DELETE("/v1/admin/users/:id/role", app.wrap(admin.ThenFunc(app.revokeRole)))
+	router.GET("/v1/admin/auth-events", app.wrap(admin.ThenFunc(app.authEvents)))
+	router.GET("/v1/admin/security", app.wrap(admin.ThenFunc(app.getSecuritySettings)))
+	router.PUT("/v1/admin/security", app.wrap(admin.ThenFunc(app.updateSecuritySettings)))
+	router.GET("/v1/admin/registration", app.wrap(admin.ThenFunc(app.getRegistration)))
+	router.PUT("/v1/admin/registration", app.wrap(admin.ThenFunc(app.updateRegistration)))
+	router.POST("/v1/admin/invitations", app.wrap(admin.ThenFunc(app.createInvitation)))
+	router.GET("/v1/admin/invitations", app.wrap(admin.ThenFunc(app.listInvitations)))
+	router.DELETE("/v1/admin/invitations/:id", app.wrap(admin.ThenFunc(app.revokeInvitation)))
+	router.POST("/v1/admin/roster", app.wrap(admin.ThenFunc(app.importRoster)))
+
+	router.POST("/v1/newreply/:thread_id", app.wrap(replyWriter.ThenFunc(app.newReply)))
+	router.GET("/v1/deletereply/:id", app.wrap(replyWriter.Append(app.requireCSRF).ThenFunc(app.deleteReply)))
+	router.POST("/v1/newthread", app.wrap(threadWriter.Append(app.requireNewAccountWork).ThenFunc(app.newThread)))
+	router.POST("/v1/editthread/", app.wrap(threadWriter.ThenFunc(app.editThread)))
+	router.PUT("/v1/togglesolved/:id", app.wrap(threadWriter.ThenFunc(app.toggleSolved)))
+	router.PUT("/v1/toggleanswer/:reply_id", app.wrap(threadWriter.ThenFunc(app.toggleAnswer)))
+	router.GET("/v1/deletethread/:id", app.wrap(threadWriter.Append(app.requireCSRF).ThenFunc(app.deleteThread)))
+	router.GET("/v1/yourthreads/:author_id", app.wrap(reader.ThenFunc(app.yourThreads)))
+	router.PUT("/v1/thread/:id/vote", app.wrap(threadWriter.ThenFunc(app.voteThread)))
+	router.DELETE("/v1/thread/:id/vote", app.wrap(threadWriter.ThenFunc(app.unvoteThread)))
+	router.PATCH("/v1/reply/:id", app.wrap(replyWriter.ThenFunc(app.editReply)))
+	router.PUT("/v1/reply/:id/vote", app.wrap(replyWriter.ThenFunc(app.voteReply)))
+	router.DELETE("/v1/reply/:id/vote", app.wrap(replyWriter.ThenFunc(app.unvoteReply)))
 	
-	router.HandlerFunc(http.MethodPost, "/v1/star/:user_id/:thread_id", app.starThread)
-    router.HandlerFunc(http.MethodDelete, "/v1/unstar/:user_id/:thread_id", app.unstarThread)
-    router.HandlerFunc(http.MethodGet, "/v1/starred/:user_id", app.getStarredThreads)
+	router.POST("/v1/star/:user_id/:thread_id", app.wrap(threadWriter.ThenFunc(app.starThread)))
+    router.DELETE("/v1/unstar/:user_id/:thread_id", app.wrap(threadWriter.ThenFunc(app.unstarThread)))
+    router.GET("/v1/starred/:user_id", app.wrap(reader.ThenFunc(app.getStarredThreads)))
 	
 	return app.enableCORS(router)
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.models.DB.SessionsForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	current := app.authenticatedSessionID(r)
+	for _, session := range sessions {
+		session.Current = session.ID == current
+	}
+
+	err = app.writeJSON(w, http.StatusOK, sessions, "sessions")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.RevokeSession(id, app.authenticatedUserID(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
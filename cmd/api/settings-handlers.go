@@ -0,0 +1,136 @@
+package main
+
+import (
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	notificationChannels = []string{"email", "in_app"}
+	digestFrequencies    = []string{"never", "daily", "weekly"}
+	validLocale          = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+)
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSettings(s *models.UserSettings) error {
+	if _, err := time.LoadLocation(s.TimeZone); err != nil || s.TimeZone == "" || s.TimeZone == "Local" {
+		return errors.New("unknown time_zone " + strconv.Quote(s.TimeZone))
+	}
+
+	if !contains(models.ThreadSorts, s.ThreadSort) {
+		return errors.New("unknown thread_sort " + strconv.Quote(s.ThreadSort))
+	}
+
+	if s.NotificationChannels == nil {
+		s.NotificationChannels = []string{}
+	}
+	seen := map[string]bool{}
+	for _, channel := range s.NotificationChannels {
+		if !contains(notificationChannels, channel) {
+			return errors.New("unknown notification channel " + strconv.Quote(channel))
+		}
+		if seen[channel] {
+			return errors.New("duplicate notification channel " + strconv.Quote(channel))
+		}
+		seen[channel] = true
+	}
+
+	if !contains(digestFrequencies, s.DigestFrequency) {
+		return errors.New("digest_frequency must be never, daily or weekly")
+	}
+
+	if !validLocale.MatchString(s.Locale) {
+		return errors.New("invalid locale " + strconv.Quote(s.Locale))
+	}
+
+	return nil
+}
+
+// settingsFor returns the settings that apply to the request: the signed in
+// user's, or the defaults.
+func (app *application) settingsFor(r *http.Request) models.UserSettings {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		return models.DefaultUserSettings()
+	}
+
+	settings, err := app.models.DB.GetUserSettings(userID)
+	if err != nil {
+		app.logger.Println(err)
+		return models.DefaultUserSettings()
+	}
+	return *settings
+}
+
+func (app *application) getSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.models.DB.GetUserSettings(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, settings, "settings")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// updateSettings changes the settings present in the body and leaves the
+// others alone.
+func (app *application) updateSettings(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	settings, err := app.models.DB.GetUserSettings(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(settings)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload: "+err.Error()))
+		return
+	}
+
+	err = validateSettings(settings)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.SetUserSettings(userID, settings)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, settings, "settings")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
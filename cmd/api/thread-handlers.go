@@ -1,17 +1,27 @@
 package main
 
 import (
+	"backend/badges"
 	"backend/models"
+	"backend/textdiff"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// maxReplyLength bounds replies, and with them the revisions that are
+// diffed for anyone reading a reply's history.
+const maxReplyLength = 20000
+
 type jsonResponse struct {
 	OK      bool   `json:"ok"`
 	Message string `json:"message"`
@@ -28,6 +38,21 @@ func (app *application) getOneThread(w http.ResponseWriter, r *http.Request) {
 	}
 
 	thread, err := app.models.DB.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("thread not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	votes, err := app.models.DB.UserVotes(app.authenticatedUserID(r), models.VoteThread, []int{thread.ID})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	thread.MyVote = votes[thread.ID]
 
 	/* thread := models.Thread{
 		ID:         id,
@@ -41,15 +66,48 @@ func (app *application) getOneThread(w http.ResponseWriter, r *http.Request) {
 		IsSolved:   false,
 	} */
 
-	app.writeJSON(w, http.StatusOK, thread, "thread")
+	err = app.writeJSON(w, http.StatusOK, thread, "thread")
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 }
 
+// threadQuery reads ?sort= and ?show_solved= from r, falling back to the
+// caller's settings.
+func (app *application) threadQuery(r *http.Request) (models.ThreadQuery, error) {
+	settings := app.settingsFor(r)
+	q := models.ThreadQuery{
+		Sort:       settings.ThreadSort,
+		HideSolved: !settings.ShowSolvedThreads,
+	}
+
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		if !contains(models.ThreadSorts, sort) {
+			return q, errors.New("unknown sort " + strconv.Quote(sort))
+		}
+		q.Sort = sort
+	}
+
+	if v := r.URL.Query().Get("show_solved"); v != "" {
+		show, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, errors.New("show_solved must be true or false")
+		}
+		q.HideSolved = !show
+	}
+
+	return q, nil
+}
+
 func (app *application) getAllThreads(w http.ResponseWriter, r *http.Request) {
-	threads, err := app.models.DB.All()
+	q, err := app.threadQuery(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	threads, err := app.models.DB.All(q)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -88,7 +146,14 @@ func (app *application) getAllThreadsByCategory(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	threads, err := app.models.DB.All(categoryID)
+	q, err := app.threadQuery(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	q.CategoryID = categoryID
+
+	threads, err := app.models.DB.All(q)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -103,10 +168,8 @@ func (app *application) getAllThreadsByCategory(w http.ResponseWriter, r *http.R
 }
 
 func (app *application) yourThreads(w http.ResponseWriter, r *http.Request) {
-    params := httprouter.ParamsFromContext(r.Context())
-    authorID, err := strconv.Atoi(params.ByName("author_id"))
-    if err != nil {
-        app.errorJSON(w, err)
+    authorID, mine := app.callerParam(w, r, "author_id")
+    if !mine {
         return
     }
 
@@ -123,6 +186,57 @@ func (app *application) yourThreads(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// callerParam reads the user ID in the URL parameter name, which has to be
+// the caller's own.
+func (app *application) callerParam(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	userID, err := strconv.Atoi(params.ByName(name))
+	if err != nil {
+		app.errorJSON(w, err)
+		return 0, false
+	}
+
+	if userID != app.authenticatedUserID(r) {
+		app.errorJSON(w, errors.New("forbidden - not your account"), http.StatusForbidden)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// authorize writes a 403 and returns false unless the caller may modify
+// content written by authorID.
+func (app *application) authorize(w http.ResponseWriter, r *http.Request, authorID int) bool {
+	if !app.canModify(r, authorID) {
+		app.errorJSON(w, errors.New("forbidden - not the author"), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// author resolves the signed in user for content creation and rejects
+// payloads claiming to be written by someone else.
+func (app *application) author(w http.ResponseWriter, r *http.Request, authorID int, authorName string) (*models.User, bool) {
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized - unknown user"), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if (authorID != 0 && authorID != user.UserID) || (authorName != "" && authorName != user.Username) {
+		app.errorJSON(w, errors.New("forbidden - cannot post as another user"), http.StatusForbidden)
+		return nil, false
+	}
+
+	if user.EmailVerifiedAt == nil && app.emailVerificationRequired() {
+		app.errorJSON(w, errors.New("forbidden - verify your email address before posting"), http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
 type ThreadPayload struct {
 	ID         string `json:"id"`
 	Title      string `json:"title"`
@@ -138,6 +252,7 @@ type ReplyPayload struct {
 	AuthorID   int       `json:"author_id"`
 	AuthorName string    `json:"author_name"`
 	ThreadID   int       `json:"thread_id"`
+	ParentID   *int      `json:"parent_id"`
 	CreatedAt  time.Time `json:"created_at"`
 }
 
@@ -151,29 +266,23 @@ func (app *application) editThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var thread models.Thread
-
-	if payload.ID != "0" {
-		id, err := strconv.Atoi(payload.ID)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-		t, err := app.models.DB.Get(id)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-		thread = *t
-		thread.UpdatedAt = time.Now()
-
+	id, err := strconv.Atoi(payload.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
 
-	thread.ID, err = strconv.Atoi(payload.ID)
+	t, err := app.models.DB.Get(id)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
+
+	if !app.authorize(w, r, t.AuthorID) {
+		return
+	}
+
+	thread := *t
 	thread.Title = payload.Title
 	thread.Content = payload.Content
 	thread.CategoryID = payload.Category
@@ -206,12 +315,17 @@ func (app *application) newThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, authorized := app.author(w, r, payload.AuthorID, payload.AuthorName)
+	if !authorized {
+		return
+	}
+
 	var thread models.Thread
 
 	thread.Title = payload.Title
 	thread.Content = payload.Content
-	thread.AuthorID = payload.AuthorID
-	thread.AuthorName = payload.AuthorName
+	thread.AuthorID = user.UserID
+	thread.AuthorName = user.Username
 	thread.CategoryID = payload.Category
 	thread.UpdatedAt = time.Now()
 	thread.CreatedAt = time.Now()
@@ -222,6 +336,8 @@ func (app *application) newThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.awardBadges(user.UserID, badges.EventThreadPosted)
+
 	ok := jsonResponse{OK: true}
 
 	err = app.writeJSON(w, http.StatusOK, ok, "response")
@@ -240,6 +356,16 @@ func (app *application) deleteThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	thread, err := app.models.DB.Get(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, thread.AuthorID) {
+		return
+	}
+
 	err = app.models.DB.DeleteThread(id)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -261,13 +387,27 @@ func (app *application) toggleSolved(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thread, err := app.models.DB.ToggleSolved(id)
+	existing, err := app.models.DB.Get(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, existing.AuthorID) {
+		return
+	}
+
+	thread, err := app.models.DB.ToggleSolved(id, app.authenticatedUserID(r))
 	if err != nil {
 		app.logger.Print(err)
 		app.errorJSON(w, err)
 		return
 	}
 
+	if thread.IsSolved {
+		app.awardBadges(thread.AuthorID, badges.EventThreadSolved)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, thread, "thread")
 	if err != nil {
 		app.logger.Print(err)
@@ -284,13 +424,34 @@ func (app *application) toggleAnswer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reply, err := app.models.DB.ToggleAnswer(id)
+	existing, err := app.models.DB.GetReply(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Only the asker (or a privileged user) decides which reply answers a thread.
+	thread, err := app.models.DB.Get(existing.ThreadID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, thread.AuthorID) {
+		return
+	}
+
+	reply, err := app.models.DB.ToggleAnswer(id, app.authenticatedUserID(r))
 	if err != nil {
 		app.logger.Print(err)
 		app.errorJSON(w, err)
 		return
 	}
 
+	if reply.IsAnswer {
+		app.awardBadges(reply.AuthorID, badges.EventAnswerAccepted)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, reply, "reply")
 	if err != nil {
 		app.logger.Print(err)
@@ -307,13 +468,22 @@ func (app *application) getReplies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	replies, err := app.models.DB.GetReplies(threadID)
+	opts, err := app.replyTreeOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	replies, err := app.threadReplies(r, threadID, opts.sort)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, replies, "replies")
+	roots, _ := replyTree(replies)
+	pruneReplies(roots, opts.depth, opts.limit)
+
+	err = app.writeJSON(w, http.StatusOK, roots, "replies")
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -338,12 +508,23 @@ func (app *application) newReply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if utf8.RuneCountInString(payload.Content) > maxReplyLength {
+		app.errorJSON(w, errors.New("content must be at most "+strconv.Itoa(maxReplyLength)+" characters"))
+		return
+	}
+
+	user, authorized := app.author(w, r, payload.AuthorID, payload.AuthorName)
+	if !authorized {
+		return
+	}
+
 	var reply models.Reply
 
 	reply.Content = payload.Content
-	reply.AuthorID = payload.AuthorID
-	reply.AuthorName = payload.AuthorName
+	reply.AuthorID = user.UserID
+	reply.AuthorName = user.Username
 	reply.ThreadID = threadID
+	reply.ParentID = payload.ParentID
 	reply.CreatedAt = time.Now()
 
 	err = app.models.DB.InsertReply(reply)
@@ -352,6 +533,8 @@ func (app *application) newReply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.awardBadges(user.UserID, badges.EventReplyPosted)
+
 	ok := jsonResponse{OK: true}
 	err = app.writeJSON(w, http.StatusOK, ok, "response")
 	if err != nil {
@@ -369,6 +552,16 @@ func (app *application) deleteReply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reply, err := app.models.DB.GetReply(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, reply.AuthorID) {
+		return
+	}
+
 	err = app.models.DB.DeleteReply(id)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -381,15 +574,125 @@ func (app *application) deleteReply(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (app *application) starThread(w http.ResponseWriter, r *http.Request) {
+type EditReplyPayload struct {
+	Content string `json:"content"`
+}
+
+// editReply changes the content of a reply. The author and moderators may
+// edit it; the version replaced is kept, see getReplyRevisions.
+func (app *application) editReply(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload EditReplyPayload
+
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	if strings.TrimSpace(payload.Content) == "" {
+		app.errorJSON(w, errors.New("content must not be empty"))
+		return
+	}
+	if utf8.RuneCountInString(payload.Content) > maxReplyLength {
+		app.errorJSON(w, errors.New("content must be at most "+strconv.Itoa(maxReplyLength)+" characters"))
+		return
+	}
+
+	existing, err := app.models.DB.GetReply(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && existing.Deleted) {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, existing.AuthorID) {
+		return
+	}
+
+	reply, err := app.models.DB.UpdateReply(id, payload.Content, app.authenticatedUserID(r))
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, models.ErrReplyDeleted) {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, reply, "reply")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getReplyRevisions lists every version of a reply, oldest first, each with
+// the unified diff from the version before it. Earlier versions may hold
+// what the author took back, so only the author and moderators see them.
+func (app *application) getReplyRevisions(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	userID, err := strconv.Atoi(params.ByName("user_id"))
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	reply, err := app.models.DB.GetReply(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && reply.Deleted) {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.authorize(w, r, reply.AuthorID) {
+		return
+	}
+
+	revisions, err := app.models.DB.ReplyRevisions(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("reply not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	for i := 1; i < len(revisions); i++ {
+		prev, cur := revisions[i-1], revisions[i]
+		cur.Diff = textdiff.Unified(prev.Content, cur.Content,
+			fmt.Sprintf("revision %d", prev.Revision), fmt.Sprintf("revision %d", cur.Revision))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, revisions, "revisions")
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
+}
 
+func (app *application) starThread(w http.ResponseWriter, r *http.Request) {
+	userID, mine := app.callerParam(w, r, "user_id")
+	if !mine {
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
 	threadID, err := strconv.Atoi(params.ByName("thread_id"))
 	if err != nil {
 		app.errorJSON(w, err)
@@ -412,14 +715,12 @@ func (app *application) starThread(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) unstarThread(w http.ResponseWriter, r *http.Request) {
-	params := httprouter.ParamsFromContext(r.Context())
-
-	userID, err := strconv.Atoi(params.ByName("user_id"))
-	if err != nil {
-		app.errorJSON(w, err)
+	userID, mine := app.callerParam(w, r, "user_id")
+	if !mine {
 		return
 	}
 
+	params := httprouter.ParamsFromContext(r.Context())
 	threadID, err := strconv.Atoi(params.ByName("thread_id"))
 	if err != nil {
 		app.errorJSON(w, err)
@@ -442,10 +743,8 @@ func (app *application) unstarThread(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) getStarredThreads (w http.ResponseWriter, r *http.Request) {
-	params := httprouter.ParamsFromContext(r.Context())
-	userID, err := strconv.Atoi(params.ByName("user_id"))
-	if err != nil {
-		app.errorJSON(w, err)
+	userID, mine := app.callerParam(w, r, "user_id")
+	if !mine {
 		return
 	}
 
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"backend/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Failures older than this no longer count towards a lockout.
+const loginFailureWindow = time.Hour
+
+func loginKeys(username, ip string) (userKey, ipKey string) {
+	return "user:" + strings.ToLower(username), "ip:" + ip
+}
+
+// lockoutDuration doubles the lock for every failure past threshold, starting
+// at base and capped at max. It is zero below the threshold.
+func lockoutDuration(failures, threshold int, base, max time.Duration) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	d := base
+	for i := threshold; i < failures; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+// loginLockedFor returns how long sign-in stays blocked for the username and
+// the address, or zero when neither is locked.
+func (app *application) loginLockedFor(username, ip string) time.Duration {
+	userKey, ipKey := loginKeys(username, ip)
+
+	until, err := app.models.DB.LoginLockedUntil(userKey, ipKey)
+	if err != nil {
+		app.logger.Println(err)
+		return 0
+	}
+	if until.IsZero() {
+		return 0
+	}
+
+	return time.Until(until)
+}
+
+func (app *application) recordLoginFailure(username, ip string) {
+	userKey, ipKey := loginKeys(username, ip)
+
+	app.lockAfterFailure(userKey, username, ip, app.config.login.maxUserFailures)
+	app.lockAfterFailure(ipKey, username, ip, app.config.login.maxIPFailures)
+}
+
+func (app *application) lockAfterFailure(key, username, ip string, threshold int) {
+	failures, err := app.models.DB.RecordLoginFailure(key, loginFailureWindow)
+	if err != nil {
+		return
+	}
+
+	d := lockoutDuration(failures, threshold, app.config.login.lockout, app.config.login.maxLockout)
+	if d == 0 {
+		return
+	}
+
+	err = app.models.DB.LockLogin(key, time.Now().Add(d))
+	if err != nil {
+		return
+	}
+
+	app.logger.Printf("locked %s for %s after %d failed sign-ins", key, d, failures)
+	app.models.DB.InsertAuthEvent(models.AuthEvent{
+		Event:    "lockout",
+		Username: username,
+		IP:       ip,
+		Detail:   fmt.Sprintf("%s locked for %s after %d failed sign-ins", key, d, failures),
+	})
+}
+
+func (app *application) clearLoginFailures(username string) {
+	userKey, _ := loginKeys(username, "")
+	app.models.DB.ClearLoginFailures(userKey)
+}
@@ -1,22 +1,38 @@
 package main
 
 import (
+    "backend/hasher"
     "backend/models"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "database/sql"
+    "encoding/base64"
     "encoding/json"
     "errors"
     "fmt"
+    "io"
+    "net"
     "net/http"
+    "net/mail"
+    "strconv"
+    "strings"
     "time"
 
+    "github.com/julienschmidt/httprouter"
     "github.com/pascaldekloe/jwt"
-    "golang.org/x/crypto/bcrypt"
 )
 
 
 
+// errAccountDisabled is returned on sign-in to accounts an admin disabled.
+var errAccountDisabled = errors.New("this account has been disabled")
+
 type Credentials struct {
-    Username string 
-    Password string 
+    Username   string 
+    Password   string 
+    Email      string
+    InviteCode string `json:"invite_code"`
 }
 
 func (app *application) signIn(w http.ResponseWriter, r *http.Request) {
@@ -28,39 +44,305 @@ func (app *application) signIn(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    ip := clientIP(r)
+
+    if wait := app.loginLockedFor(creds.Username, ip); wait > 0 {
+        w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+        app.errorJSON(w, errors.New("too many failed sign-in attempts, try again later"), http.StatusTooManyRequests)
+        return
+    }
+
     user, err := app.models.DB.GetUserByUsername(creds.Username)
     if err != nil {
+        app.recordLoginFailure(creds.Username, ip)
         app.errorJSON(w, errors.New("invalid username or password"))
         return
     }
 
-    err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
-    if err != nil {
+    match, rehash, err := app.passwords.Verify(user.Password, creds.Password)
+    if err != nil && !errors.Is(err, hasher.ErrUnknownFormat) {
+        app.logger.Printf("verifying password of user %d: %v", user.UserID, err)
+    }
+    if !match {
+        app.recordLoginFailure(creds.Username, ip)
         app.errorJSON(w, errors.New("invalid username or password"))
         return
     }
 
+    app.clearLoginFailures(creds.Username)
+
+    if user.DisabledAt != nil {
+        app.errorJSON(w, errAccountDisabled, http.StatusForbidden)
+        return
+    }
+
+    // Bring hashes made under an older policy up to date while we have the
+    // plaintext; failing to do so only means trying again next time.
+    if rehash {
+        if hashed, err := app.passwords.Hash(creds.Password); err == nil {
+            if err := app.models.DB.SetUserPassword(user.UserID, hashed); err == nil {
+                user.Password = hashed
+            }
+        }
+    }
+
+    app.finishSignIn(w, r, user)
+}
+
+// startSession opens a server-side session for user and responds with a
+// short-lived access token plus the refresh token that renews it.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+    if user.DisabledAt != nil {
+        app.errorJSON(w, errAccountDisabled, http.StatusForbidden)
+        return
+    }
+
+    refreshToken, hash, err := newTokenSecret()
+    if err != nil {
+        app.errorJSON(w, errors.New("error creating session"), http.StatusInternalServerError)
+        return
+    }
+
+    session := &models.Session{
+        UserID:           user.UserID,
+        RefreshTokenHash: hash,
+        UserAgent:        r.UserAgent(),
+        IP:               clientIP(r),
+        ExpiresAt:        time.Now().Add(app.config.jwt.refreshTTL),
+    }
+
+    err = app.models.DB.InsertSession(session)
+    if err != nil {
+        app.errorJSON(w, errors.New("error creating session"), http.StatusInternalServerError)
+        return
+    }
+
+    app.writeTokens(w, r, user, session.ID, refreshToken)
+}
+
+func (app *application) writeTokens(w http.ResponseWriter, r *http.Request, user *models.User, sessionID int64, refreshSecret string) {
+    expires := time.Now().Add(app.config.jwt.accessTTL)
+
+    effective := *user
+    role, enrollmentRequired := app.sessionRole(user)
+    effective.Role = role
+
+    jwtBytes, err := app.newAccessToken(&effective, sessionID, expires)
+    if err != nil {
+        app.errorJSON(w, errors.New("error signing tok"))
+        return
+    }
+
+    refreshToken := fmt.Sprintf("%d.%s", sessionID, refreshSecret)
+
+    response := map[string]interface{}{
+        "token":         string(jwtBytes), // Convert jwtBytes to string
+        "expires_at":    expires,
+        "refresh_token": refreshToken,
+        "user_id":       user.UserID,
+        "username":      user.Username,
+        "role":          effective.Role,
+    }
+
+    if app.wantsCookieSession(r) {
+        csrf := csrfToken(r)
+        if csrf == "" {
+            csrf, _, err = newTokenSecret()
+            if err != nil {
+                app.errorJSON(w, errors.New("error creating session"), http.StatusInternalServerError)
+                return
+            }
+        }
+
+        app.setSessionCookies(w, string(jwtBytes), refreshToken, csrf)
+
+        // Script must never see the tokens, only what it sends back as
+        // the CSRF header.
+        delete(response, "token")
+        delete(response, "refresh_token")
+        response["csrf_token"] = csrf
+    }
+
+    if enrollmentRequired {
+        response["mfa_enrollment_required"] = true
+    }
+
+    app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+func (app *application) newAccessToken(user *models.User, sessionID int64, expires time.Time) ([]byte, error) {
     var claims jwt.Claims
     claims.Subject = fmt.Sprint(user.UserID)
     claims.Issued = jwt.NewNumericTime(time.Now())
     claims.NotBefore = jwt.NewNumericTime(time.Now())
-    claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
-    claims.Issuer = "testing"
-    claims.Audiences = []string{"testing"}
+    claims.Expires = jwt.NewNumericTime(expires)
+    claims.Issuer = app.config.jwt.issuer
+    claims.Audiences = []string{app.config.jwt.audience}
+    claims.Set = map[string]interface{}{
+        "role": user.Role,
+        "sid":  strconv.FormatInt(sessionID, 10),
+    }
+
+    return app.keys.sign(&claims)
+}
 
-    jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+// signPurposeToken signs a short-lived token that is only accepted back by
+// checkPurposeToken for the same purpose, never as an access token.
+func (app *application) signPurposeToken(purpose, subject string, set map[string]interface{}, ttl time.Duration) (string, error) {
+    var claims jwt.Claims
+    claims.Subject = subject
+    claims.Issued = jwt.NewNumericTime(time.Now())
+    claims.Expires = jwt.NewNumericTime(time.Now().Add(ttl))
+    claims.Issuer = app.config.jwt.issuer
+    claims.Audiences = []string{app.config.jwt.issuer + "#" + purpose}
+    claims.Set = set
+    if claims.Set == nil {
+        claims.Set = map[string]interface{}{}
+    }
+
+    token, err := app.keys.sign(&claims)
     if err != nil {
-        app.errorJSON(w, errors.New("error signing tok"))
+        return "", err
+    }
+    return string(token), nil
+}
+
+func (app *application) checkPurposeToken(token, purpose string) (*jwt.Claims, error) {
+    claims, err := app.keys.check([]byte(token))
+    if err != nil {
+        return nil, errors.New("invalid token")
+    }
+
+    if !claims.Valid(time.Now()) {
+        return nil, errors.New("token expired")
+    }
+
+    if claims.Issuer != app.config.jwt.issuer || len(claims.Audiences) != 1 || claims.Audiences[0] != app.config.jwt.issuer+"#"+purpose {
+        return nil, errors.New("invalid token")
+    }
+
+    return claims, nil
+}
+
+// newTokenSecret returns a random token and the hash stored for it. Refresh
+// tokens are handed out as "<session id>.<secret>".
+func newTokenSecret() (string, []byte, error) {
+    b := make([]byte, 32)
+    _, err := rand.Read(b)
+    if err != nil {
+        return "", nil, err
+    }
+
+    secret := base64.RawURLEncoding.EncodeToString(b)
+    return secret, hashToken(secret), nil
+}
+
+func hashToken(token string) []byte {
+    sum := sha256.Sum256([]byte(token))
+    return sum[:]
+}
+
+func parseRefreshToken(token string) (int64, string, error) {
+    id, secret, found := strings.Cut(token, ".")
+    if !found || secret == "" {
+        return 0, "", errors.New("malformed refresh token")
+    }
+
+    sessionID, err := strconv.ParseInt(id, 10, 64)
+    if err != nil {
+        return 0, "", errors.New("malformed refresh token")
+    }
+
+    return sessionID, secret, nil
+}
+
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+type RefreshPayload struct {
+    RefreshToken string `json:"refresh_token"`
+}
+
+func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
+    var payload RefreshPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil && !errors.Is(err, io.EOF) {
+        app.errorJSON(w, errors.New("invalid request payload"))
         return
     }
 
-    response := map[string]interface{}{
-        "token":    string(jwtBytes), // Convert jwtBytes to string
-        "user_id":  user.UserID,
-        "username": user.Username,
+    if payload.RefreshToken == "" {
+        if cookie, err := r.Cookie(refreshCookie); err == nil {
+            if !validCSRF(r) {
+                app.errorJSON(w, errors.New("forbidden - missing or invalid CSRF token"), http.StatusForbidden)
+                return
+            }
+            payload.RefreshToken = cookie.Value
+            r = withCookieSession(r)
+        }
     }
 
-    app.writeJSON(w, http.StatusOK, response, "response")
+    sessionID, secret, err := parseRefreshToken(payload.RefreshToken)
+    if err != nil {
+        app.errorJSON(w, err, http.StatusUnauthorized)
+        return
+    }
+
+    session, err := app.models.DB.GetSession(sessionID)
+    if err != nil || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+        app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+        return
+    }
+
+    oldHash := hashToken(secret)
+    if subtle.ConstantTimeCompare(oldHash, session.RefreshTokenHash) != 1 {
+        // An already rotated token came back, so it has leaked: end the
+        // session for whoever holds the newer one as well.
+        app.logger.Printf("refresh token reuse on session %d, revoking", session.ID)
+        app.models.DB.RevokeSession(session.ID, session.UserID)
+        app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+        return
+    }
+
+    user, err := app.models.DB.GetUserByID(session.UserID)
+    if err != nil || user.DisabledAt != nil {
+        app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+        return
+    }
+
+    newSecret, newHash, err := newTokenSecret()
+    if err != nil {
+        app.errorJSON(w, errors.New("error creating session"), http.StatusInternalServerError)
+        return
+    }
+
+    err = app.models.DB.RotateSession(session.ID, oldHash, newHash, time.Now().Add(app.config.jwt.refreshTTL))
+    if err != nil {
+        app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+        return
+    }
+
+    app.writeTokens(w, r, user, session.ID, newSecret)
+}
+
+func (app *application) signOut(w http.ResponseWriter, r *http.Request) {
+    err := app.models.DB.RevokeSession(app.authenticatedSessionID(r), app.authenticatedUserID(r))
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        app.errorJSON(w, err)
+        return
+    }
+
+    app.clearSessionCookies(w)
+
+    ok := jsonResponse{OK: true}
+
+    app.writeJSON(w, http.StatusOK, ok, "response")
 }
 
 func (app *application) signUp(w http.ResponseWriter, r *http.Request) {
@@ -72,23 +354,62 @@ func (app *application) signUp(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 12)
+    creds.Username = strings.TrimSpace(creds.Username)
+    if creds.Username == "" {
+        app.errorJSON(w, errors.New("username is required"))
+        return
+    }
+
+    err = validatePassword(creds.Username, creds.Password)
     if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    creds.Email = strings.TrimSpace(creds.Email)
+    if creds.Email == "" && app.emailVerificationRequired() {
+        app.errorJSON(w, errors.New("email is required"))
+        return
+    }
+    if creds.Email != "" {
+        if _, err := mail.ParseAddress(creds.Email); err != nil {
+            app.errorJSON(w, errors.New("invalid email address"))
+            return
+        }
+    }
+
+    invitationID, err := app.admitSignup(creds.Email, creds.InviteCode, true)
+    if err != nil {
+        app.refuseSignup(w, err)
+        return
+    }
+
+    hashedPassword, err := app.passwords.Hash(creds.Password)
+    if err != nil {
+        app.abandonSignup(invitationID)
         app.errorJSON(w, errors.New("error hashing password"))
         return
     }
 
     user := &models.User{
         Username: creds.Username,
-        Password: string(hashedPassword),
+        Password: hashedPassword,
+        Email:    creds.Email,
     }
 
     err = app.models.DB.InsertUser(user)
     if err != nil {
+        app.abandonSignup(invitationID)
         app.errorJSON(w, errors.New("error inserting user"))
         return
     }
 
+    app.finishSignup(invitationID, user.UserID)
+
+    if user.Email != "" {
+        app.sendVerificationEmail(user)
+    }
+
     response := map[string]interface{}{
         "user_id":  user.UserID,
         "username": user.Username,
@@ -98,3 +419,485 @@ func (app *application) signUp(w http.ResponseWriter, r *http.Request) {
 }
 
 
+
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// PasskeyCredential is a PublicKeyCredential as serialized by the frontend,
+// with every binary field base64url encoded.
+type PasskeyCredential struct {
+    ID       string `json:"id"`
+    RawID    string `json:"rawId"`
+    Type     string `json:"type"`
+    Response struct {
+        ClientDataJSON    string `json:"clientDataJSON"`
+        AttestationObject string `json:"attestationObject"`
+        AuthenticatorData string `json:"authenticatorData"`
+        Signature         string `json:"signature"`
+        UserHandle        string `json:"userHandle"`
+    } `json:"response"`
+}
+
+type PasskeyPayload struct {
+    CeremonyToken string            `json:"ceremony_token"`
+    Name          string            `json:"name"`
+    Credential    PasskeyCredential `json:"credential"`
+}
+
+type PasskeySignUpPayload struct {
+    Username   string `json:"username"`
+    Email      string `json:"email"`
+    InviteCode string `json:"invite_code"`
+}
+
+func randomBytes(n int) ([]byte, error) {
+    b := make([]byte, n)
+    _, err := rand.Read(b)
+    return b, err
+}
+
+// beginCeremony creates a challenge and the signed ceremony token that
+// carries it, and whatever else finishing needs, back to us.
+func (app *application) beginCeremony(purpose, subject string, set map[string]interface{}) (string, string, error) {
+    challenge, err := randomBytes(32)
+    if err != nil {
+        return "", "", err
+    }
+    encoded := webauthnEncoding.EncodeToString(challenge)
+
+    if set == nil {
+        set = map[string]interface{}{}
+    }
+    set["challenge"] = encoded
+
+    token, err := app.signPurposeToken(purpose, subject, set, passkeyCeremonyTTL)
+    if err != nil {
+        return "", "", err
+    }
+
+    return encoded, token, nil
+}
+
+func (app *application) creationOptions(challenge, username string, handle []byte, exclude []*models.WebAuthnCredential) map[string]interface{} {
+    excluded := []map[string]interface{}{}
+    for _, cred := range exclude {
+        excluded = append(excluded, map[string]interface{}{
+            "type": "public-key",
+            "id":   webauthnEncoding.EncodeToString(cred.ID),
+        })
+    }
+
+    return map[string]interface{}{
+        "challenge": challenge,
+        "rp": map[string]interface{}{
+            "id":   app.webauthn.ID,
+            "name": app.webauthn.Name,
+        },
+        "user": map[string]interface{}{
+            "id":          webauthnEncoding.EncodeToString(handle),
+            "name":        username,
+            "displayName": username,
+        },
+        "pubKeyCredParams": []map[string]interface{}{
+            {"type": "public-key", "alg": coseAlgES256},
+            {"type": "public-key", "alg": coseAlgEdDSA},
+            {"type": "public-key", "alg": coseAlgRS256},
+        },
+        "timeout":     passkeyCeremonyTTL.Milliseconds(),
+        "attestation": "none",
+        "authenticatorSelection": map[string]interface{}{
+            "residentKey":      "required",
+            "userVerification": "required",
+        },
+        "excludeCredentials": excluded,
+    }
+}
+
+// verifyCreation checks a registration response against the ceremony token
+// and returns the credential to store.
+func (app *application) verifyCreation(payload PasskeyPayload, purpose string) (*jwt.Claims, *models.WebAuthnCredential, error) {
+    claims, err := app.checkPurposeToken(payload.CeremonyToken, purpose)
+    if err != nil {
+        return nil, nil, err
+    }
+    challenge, _ := claims.String("challenge")
+
+    clientData, err := decodeWebAuthnBase64(payload.Credential.Response.ClientDataJSON)
+    if err != nil {
+        return nil, nil, errors.New("malformed client data")
+    }
+    attestation, err := decodeWebAuthnBase64(payload.Credential.Response.AttestationObject)
+    if err != nil {
+        return nil, nil, errors.New("malformed attestation object")
+    }
+
+    ad, err := app.webauthn.verifyRegistration(clientData, attestation, challenge)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    name := strings.TrimSpace(payload.Name)
+    if name == "" {
+        name = "Passkey"
+    }
+    if len(name) > 100 {
+        name = name[:100]
+    }
+
+    cred := &models.WebAuthnCredential{
+        ID:        ad.CredentialID,
+        PublicKey: ad.PublicKey,
+        SignCount: ad.SignCount,
+        Name:      name,
+    }
+
+    return claims, cred, nil
+}
+
+// beginPasskeyRegistration lets a signed in user add a passkey.
+func (app *application) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+    user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    handle, err := randomBytes(32)
+    if err != nil {
+        app.errorJSON(w, err, http.StatusInternalServerError)
+        return
+    }
+    handle, err = app.models.DB.EnsureWebAuthnHandle(user.UserID, handle)
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    existing, err := app.models.DB.WebAuthnCredentialsForUser(user.UserID)
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    challenge, token, err := app.beginCeremony("passkey-register", strconv.Itoa(user.UserID), nil)
+    if err != nil {
+        app.errorJSON(w, err, http.StatusInternalServerError)
+        return
+    }
+
+    response := map[string]interface{}{
+        "public_key":     app.creationOptions(challenge, user.Username, handle, existing),
+        "ceremony_token": token,
+    }
+
+    app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+func (app *application) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+    var payload PasskeyPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        app.errorJSON(w, errors.New("invalid request payload"))
+        return
+    }
+
+    claims, cred, err := app.verifyCreation(payload, "passkey-register")
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    if claims.Subject != strconv.Itoa(app.authenticatedUserID(r)) {
+        app.errorJSON(w, errors.New("ceremony belongs to another user"), http.StatusForbidden)
+        return
+    }
+
+    cred.UserID = app.authenticatedUserID(r)
+    err = app.models.DB.InsertWebAuthnCredential(cred)
+    if err != nil {
+        app.errorJSON(w, errors.New("passkey already registered"), http.StatusConflict)
+        return
+    }
+
+    app.writeJSON(w, http.StatusCreated, cred, "passkey")
+}
+
+// beginPasskeySignUp starts creating a passkey-only account; nothing is
+// stored until the authenticator's response comes back.
+func (app *application) beginPasskeySignUp(w http.ResponseWriter, r *http.Request) {
+    var payload PasskeySignUpPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        app.errorJSON(w, errors.New("invalid request payload"))
+        return
+    }
+
+    payload.Username = strings.TrimSpace(payload.Username)
+    if payload.Username == "" {
+        app.errorJSON(w, errors.New("username is required"))
+        return
+    }
+
+    payload.Email = strings.TrimSpace(payload.Email)
+    if payload.Email == "" && app.emailVerificationRequired() {
+        app.errorJSON(w, errors.New("email is required"))
+        return
+    }
+    if payload.Email != "" {
+        if _, err := mail.ParseAddress(payload.Email); err != nil {
+            app.errorJSON(w, errors.New("invalid email address"))
+            return
+        }
+    }
+
+    if _, err := app.models.DB.GetUserByUsername(payload.Username); err == nil {
+        app.errorJSON(w, errors.New("username is taken"), http.StatusConflict)
+        return
+    }
+
+    if _, err := app.admitSignup(payload.Email, payload.InviteCode, false); err != nil {
+        app.refuseSignup(w, err)
+        return
+    }
+
+    handle, err := randomBytes(32)
+    if err != nil {
+        app.errorJSON(w, err, http.StatusInternalServerError)
+        return
+    }
+
+    challenge, token, err := app.beginCeremony("passkey-signup", "", map[string]interface{}{
+        "username": payload.Username,
+        "email":    payload.Email,
+        "invite":   payload.InviteCode,
+        "handle":   webauthnEncoding.EncodeToString(handle),
+    })
+    if err != nil {
+        app.errorJSON(w, err, http.StatusInternalServerError)
+        return
+    }
+
+    response := map[string]interface{}{
+        "public_key":     app.creationOptions(challenge, payload.Username, handle, nil),
+        "ceremony_token": token,
+    }
+
+    app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+func (app *application) finishPasskeySignUp(w http.ResponseWriter, r *http.Request) {
+    var payload PasskeyPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        app.errorJSON(w, errors.New("invalid request payload"))
+        return
+    }
+
+    claims, cred, err := app.verifyCreation(payload, "passkey-signup")
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    username, _ := claims.String("username")
+    email, _ := claims.String("email")
+    encodedHandle, _ := claims.String("handle")
+    handle, err := webauthnEncoding.DecodeString(encodedHandle)
+    if err != nil || username == "" {
+        app.errorJSON(w, errors.New("invalid ceremony token"))
+        return
+    }
+
+    inviteCode, _ := claims.String("invite")
+    invitationID, err := app.admitSignup(email, inviteCode, true)
+    if err != nil {
+        app.refuseSignup(w, err)
+        return
+    }
+
+    user := &models.User{Username: username, Email: email}
+
+    err = app.models.DB.InsertUserWithCredential(user, handle, cred)
+    if err != nil {
+        app.abandonSignup(invitationID)
+        app.errorJSON(w, errors.New("error inserting user"), http.StatusConflict)
+        return
+    }
+
+    app.finishSignup(invitationID, user.UserID)
+
+    if user.Email != "" {
+        app.sendVerificationEmail(user)
+    }
+
+    app.startSession(w, r, user)
+}
+
+type PasskeyLoginPayload struct {
+    Username string `json:"username"`
+}
+
+// beginPasskeyLogin issues an assertion challenge. Without a username the
+// browser offers the user's discoverable passkeys for this site.
+func (app *application) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+    var payload PasskeyLoginPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil && !errors.Is(err, io.EOF) {
+        app.errorJSON(w, errors.New("invalid request payload"))
+        return
+    }
+
+    allowed := []map[string]interface{}{}
+    if payload.Username != "" {
+        // Unknown usernames get an empty list rather than an error so the
+        // endpoint does not reveal which accounts exist.
+        if user, err := app.models.DB.GetUserByUsername(payload.Username); err == nil {
+            creds, err := app.models.DB.WebAuthnCredentialsForUser(user.UserID)
+            if err == nil {
+                for _, cred := range creds {
+                    allowed = append(allowed, map[string]interface{}{
+                        "type": "public-key",
+                        "id":   webauthnEncoding.EncodeToString(cred.ID),
+                    })
+                }
+            }
+        }
+    }
+
+    challenge, token, err := app.beginCeremony("passkey-login", "", nil)
+    if err != nil {
+        app.errorJSON(w, err, http.StatusInternalServerError)
+        return
+    }
+
+    response := map[string]interface{}{
+        "public_key": map[string]interface{}{
+            "challenge":        challenge,
+            "rpId":             app.webauthn.ID,
+            "timeout":          passkeyCeremonyTTL.Milliseconds(),
+            "userVerification": "required",
+            "allowCredentials": allowed,
+        },
+        "ceremony_token": token,
+    }
+
+    app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+func (app *application) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+    var payload PasskeyPayload
+
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        app.errorJSON(w, errors.New("invalid request payload"))
+        return
+    }
+
+    claims, err := app.checkPurposeToken(payload.CeremonyToken, "passkey-login")
+    if err != nil {
+        app.errorJSON(w, err, http.StatusUnauthorized)
+        return
+    }
+    challenge, _ := claims.String("challenge")
+
+    resp := payload.Credential.Response
+    rawID, err1 := decodeWebAuthnBase64(payload.Credential.RawID)
+    clientData, err2 := decodeWebAuthnBase64(resp.ClientDataJSON)
+    authData, err3 := decodeWebAuthnBase64(resp.AuthenticatorData)
+    signature, err4 := decodeWebAuthnBase64(resp.Signature)
+    userHandle, err5 := decodeWebAuthnBase64(resp.UserHandle)
+    if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+        app.errorJSON(w, errors.New("malformed credential"))
+        return
+    }
+
+    cred, handle, err := app.models.DB.GetWebAuthnCredential(rawID)
+    if err != nil {
+        app.errorJSON(w, errors.New("unknown passkey"), http.StatusUnauthorized)
+        return
+    }
+
+    user, err := app.models.DB.GetUserByID(cred.UserID)
+    if err != nil {
+        app.errorJSON(w, errors.New("unknown passkey"), http.StatusUnauthorized)
+        return
+    }
+
+    ip := clientIP(r)
+    if wait := app.loginLockedFor(user.Username, ip); wait > 0 {
+        w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+        app.errorJSON(w, errors.New("too many failed sign-in attempts, try again later"), http.StatusTooManyRequests)
+        return
+    }
+
+    if len(userHandle) > 0 && subtle.ConstantTimeCompare(userHandle, handle) != 1 {
+        app.recordLoginFailure(user.Username, ip)
+        app.errorJSON(w, errors.New("passkey does not belong to this user"), http.StatusUnauthorized)
+        return
+    }
+
+    ad, err := app.webauthn.verifyAssertion(cred.PublicKey, clientData, authData, signature, challenge)
+    if err != nil {
+        app.recordLoginFailure(user.Username, ip)
+        app.errorJSON(w, err, http.StatusUnauthorized)
+        return
+    }
+
+    if signCountRegressed(cred.SignCount, ad.SignCount) {
+        app.logger.Printf("passkey %x of user %d: sign count %d after %d, possibly cloned", cred.ID, user.UserID, ad.SignCount, cred.SignCount)
+        app.errorJSON(w, errors.New("passkey rejected"), http.StatusUnauthorized)
+        return
+    }
+
+    err = app.models.DB.UpdateWebAuthnSignCount(cred.ID, ad.SignCount)
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    app.clearLoginFailures(user.Username)
+
+    // A user-verified passkey already is a second factor.
+    app.startSession(w, r, user)
+}
+
+func (app *application) listPasskeys(w http.ResponseWriter, r *http.Request) {
+    creds, err := app.models.DB.WebAuthnCredentialsForUser(app.authenticatedUserID(r))
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, creds, "passkeys")
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+}
+
+func (app *application) deletePasskey(w http.ResponseWriter, r *http.Request) {
+    params := httprouter.ParamsFromContext(r.Context())
+
+    id, err := decodeWebAuthnBase64(params.ByName("id"))
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+
+    deleted, err := app.models.DB.DeleteWebAuthnCredential(id, app.authenticatedUserID(r))
+    if err != nil {
+        app.errorJSON(w, err)
+        return
+    }
+    if !deleted {
+        app.errorJSON(w, errors.New("passkey not found or it is the last way to sign in"), http.StatusConflict)
+        return
+    }
+
+    ok := jsonResponse{OK: true}
+
+    app.writeJSON(w, http.StatusOK, ok, "response")
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"backend/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const mfaChallengeTTL = 5 * time.Minute
+
+// require2FARoles returns the roles whose members must use two-factor
+// sign-in before their role takes effect.
+func (app *application) require2FARoles() []string {
+	var roles []string
+	_, err := app.models.DB.GetSiteSetting(models.SiteSettingRequire2FARoles, &roles)
+	if err != nil {
+		app.logger.Println(err)
+	}
+	return roles
+}
+
+// sessionRole is the role a new access token carries for user. Members of a
+// role that requires two-factor sign-in act as plain users until they enroll.
+func (app *application) sessionRole(user *models.User) (role string, enrollmentRequired bool) {
+	if user.TOTPEnabled || user.Role == models.RoleUser {
+		return user.Role, false
+	}
+
+	for _, required := range app.require2FARoles() {
+		if user.Role == required {
+			return models.RoleUser, true
+		}
+	}
+
+	return user.Role, false
+}
+
+// finishSignIn opens a session for user, who proved the first factor,
+// or asks for the second one first.
+func (app *application) finishSignIn(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.TOTPEnabled {
+		app.writeMFAChallenge(w, r, user)
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+// writeMFAChallenge answers the first factor of a user with two-factor
+// sign-in; the challenge token is exchanged for a session by signInTOTP.
+// It remembers whether the session is to live in cookies, as signInTOTP
+// may be called without ?session=cookie.
+func (app *application) writeMFAChallenge(w http.ResponseWriter, r *http.Request, user *models.User) {
+	set := map[string]interface{}{}
+	if app.wantsCookieSession(r) {
+		set["cookie"] = true
+	}
+
+	challenge, err := app.signPurposeToken("mfa", strconv.Itoa(user.UserID), set, mfaChallengeTTL)
+	if err != nil {
+		app.errorJSON(w, errors.New("error signing tok"))
+		return
+	}
+
+	response := map[string]interface{}{
+		"mfa_required":    true,
+		"challenge_token": challenge,
+	}
+
+	app.writeJSON(w, http.StatusOK, response, "response")
+}
+
+type TOTPSignInPayload struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+func (app *application) signInTOTP(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPSignInPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	claims, err := app.checkPurposeToken(payload.ChallengeToken, "mfa")
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.models.DB.GetUserByID(userID)
+	if err != nil || !user.TOTPEnabled {
+		app.errorJSON(w, errors.New("invalid or expired challenge"), http.StatusUnauthorized)
+		return
+	}
+
+	ip := clientIP(r)
+	if wait := app.loginLockedFor(user.Username, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		app.errorJSON(w, errors.New("too many failed sign-in attempts, try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	if !app.checkSecondFactor(user, payload.Code, payload.RecoveryCode) {
+		app.recordLoginFailure(user.Username, ip)
+		app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+
+	app.clearLoginFailures(user.Username)
+
+	if cookieSession, _ := claims.Set["cookie"].(bool); cookieSession {
+		r = withCookieSession(r)
+	}
+
+	app.startSession(w, r, user)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code, consuming whichever matched.
+func (app *application) checkSecondFactor(user *models.User, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		ok, err := app.models.DB.UseRecoveryCode(user.UserID, hashRecoveryCode(recoveryCode))
+		return err == nil && ok
+	}
+
+	secret, lastStep, err := app.models.DB.GetTOTPSecret(user.UserID)
+	if err != nil {
+		return false
+	}
+
+	step, ok := verifyTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return false
+	}
+
+	ok, err = app.models.DB.UseTOTPStep(user.UserID, step)
+	return err == nil && ok
+}
+
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.errorJSON(w, errors.New("two-factor sign-in is already enabled"), http.StatusConflict)
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.SetPendingTOTPSecret(user.UserID, secret)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": totpProvisioningURI(app.config.jwt.issuer, user.Username, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+type TOTPCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+}
+
+// confirmTOTP enables two-factor sign-in once the authenticator produced a
+// valid code, and hands out the recovery codes exactly once.
+func (app *application) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	secret, lastStep, err := app.models.DB.GetTOTPSecret(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("start enrollment first"))
+		return
+	}
+
+	step, ok := verifyTOTP(secret, payload.Code, time.Now(), lastStep)
+	if !ok {
+		app.errorJSON(w, errors.New("invalid code"))
+		return
+	}
+	app.models.DB.UseTOTPStep(userID, step)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.EnableTOTP(userID, hashes)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"recovery_codes": codes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		app.errorJSON(w, errors.New("two-factor sign-in is not enabled"))
+		return
+	}
+
+	for _, role := range app.require2FARoles() {
+		if user.Role == role {
+			app.errorJSON(w, errors.New("two-factor sign-in is required for your role"), http.StatusForbidden)
+			return
+		}
+	}
+
+	// Accounts that sign in through OIDC or passkeys have no password; a
+	// fresh code, or a recovery code, has to do for them.
+	if user.Password == "" {
+		if !app.checkSecondFactor(user, payload.Code, payload.RecoveryCode) {
+			app.errorJSON(w, errors.New("invalid code"), http.StatusForbidden)
+			return
+		}
+	} else {
+		match, _, _ := app.passwords.Verify(user.Password, payload.Password)
+		if !match || !app.checkSecondFactor(user, payload.Code, "") {
+			app.errorJSON(w, errors.New("invalid password or code"), http.StatusForbidden)
+			return
+		}
+	}
+
+	err = app.models.DB.DisableTOTP(user.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ok := jsonResponse{OK: true}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+type SecuritySettingsPayload struct {
+	Require2FARoles []string `json:"require_2fa_roles"`
+}
+
+func (app *application) getSecuritySettings(w http.ResponseWriter, r *http.Request) {
+	settings := SecuritySettingsPayload{Require2FARoles: app.require2FARoles()}
+	if settings.Require2FARoles == nil {
+		settings.Require2FARoles = []string{}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, settings, "settings")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) updateSecuritySettings(w http.ResponseWriter, r *http.Request) {
+	var payload SecuritySettingsPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	roles := []string{}
+	for _, role := range payload.Require2FARoles {
+		if role != models.RoleModerator && role != models.RoleAdmin {
+			app.errorJSON(w, errors.New("two-factor sign-in can only be required for moderator and admin"))
+			return
+		}
+		roles = append(roles, role)
+	}
+
+	err = app.models.DB.SetSiteSetting(models.SiteSettingRequire2FARoles, roles)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.logger.Printf("user %d required two-factor sign-in for %v", app.authenticatedUserID(r), roles)
+
+	err = app.writeJSON(w, http.StatusOK, SecuritySettingsPayload{Require2FARoles: roles}, "settings")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"backend/models"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// TestFinishSignInTOTP checks that a user with two-factor sign-in gets a
+// challenge instead of a session however the first factor was proven, and
+// that the challenge carries the session mode to signInTOTP.
+func TestFinishSignInTOTP(t *testing.T) {
+	app := newTestApplication(t)
+	user := &models.User{UserID: 7, Username: "ada", Role: models.RoleAdmin, TOTPEnabled: true}
+
+	for _, cookie := range []bool{false, true} {
+		t.Run("cookie="+strconv.FormatBool(cookie), func(t *testing.T) {
+			// The OIDC callback marks cookie sessions this way.
+			r := httptest.NewRequest("GET", "/v1/oidc/callback", nil)
+			if cookie {
+				r = withCookieSession(r)
+			}
+			w := httptest.NewRecorder()
+
+			app.finishSignIn(w, r, user)
+
+			var body struct {
+				Response struct {
+					MFARequired    bool   `json:"mfa_required"`
+					ChallengeToken string `json:"challenge_token"`
+					Token          string `json:"token"`
+				} `json:"response"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatalf("%v in %s", err, w.Body)
+			}
+			if !body.Response.MFARequired || body.Response.Token != "" {
+				t.Fatalf("got %s, want a second factor challenge", w.Body)
+			}
+			if len(w.Result().Cookies()) != 0 {
+				t.Error("session cookies set before the second factor")
+			}
+
+			claims, err := app.checkPurposeToken(body.Response.ChallengeToken, "mfa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "7" {
+				t.Errorf("challenge for user %q, want 7", claims.Subject)
+			}
+			if got, _ := claims.Set["cookie"].(bool); got != cookie {
+				t.Errorf("challenge cookie flag %t, want %t", got, cookie)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"backend/badges"
+	"backend/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type VotePayload struct {
+	Value int `json:"value"`
+}
+
+type VoteResponse struct {
+	Score  int `json:"score"`
+	MyVote int `json:"my_vote"`
+}
+
+// voteThread sets the caller's vote on a thread to {"value": 1} or
+// {"value": -1}. Repeating a vote is harmless.
+func (app *application) voteThread(w http.ResponseWriter, r *http.Request) {
+	app.castVote(w, r, models.VoteThread)
+}
+
+func (app *application) unvoteThread(w http.ResponseWriter, r *http.Request) {
+	app.setVote(w, r, models.VoteThread, 0)
+}
+
+func (app *application) voteReply(w http.ResponseWriter, r *http.Request) {
+	app.castVote(w, r, models.VoteReply)
+}
+
+func (app *application) unvoteReply(w http.ResponseWriter, r *http.Request) {
+	app.setVote(w, r, models.VoteReply, 0)
+}
+
+func (app *application) castVote(w http.ResponseWriter, r *http.Request, target models.VoteTarget) {
+	var payload VotePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid request payload"))
+		return
+	}
+
+	if payload.Value != 1 && payload.Value != -1 {
+		app.errorJSON(w, errors.New("value must be 1 or -1"))
+		return
+	}
+
+	user, err := app.models.DB.GetUserByID(app.authenticatedUserID(r))
+	if err != nil {
+		app.errorJSON(w, errors.New("unauthorized - unknown user"), http.StatusUnauthorized)
+		return
+	}
+	if user.EmailVerifiedAt == nil && app.emailVerificationRequired() {
+		app.errorJSON(w, errors.New("forbidden - verify your email address before voting"), http.StatusForbidden)
+		return
+	}
+
+	app.setVote(w, r, target, payload.Value)
+}
+
+func (app *application) setVote(w http.ResponseWriter, r *http.Request, target models.VoteTarget, value int) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	score, err := app.models.DB.SetVote(app.authenticatedUserID(r), target, id, value)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New(string(target)+" not found"), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrSelfVote) {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if value == 1 {
+		app.awardVotedBadges(target, id)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, VoteResponse{Score: score, MyVote: value}, "vote")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// awardVotedBadges checks the upvote badges of the author of what was voted
+// on.
+func (app *application) awardVotedBadges(target models.VoteTarget, id int) {
+	var authorID int
+	switch target {
+	case models.VoteThread:
+		thread, err := app.models.DB.Get(id)
+		if err != nil {
+			app.logger.Println(err)
+			return
+		}
+		authorID = thread.AuthorID
+	case models.VoteReply:
+		reply, err := app.models.DB.GetReply(id)
+		if err != nil {
+			app.logger.Println(err)
+			return
+		}
+		authorID = reply.AuthorID
+	}
+
+	app.awardBadges(authorID, badges.EventUpvoted)
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// This file verifies WebAuthn registration and assertion responses
+// (https://www.w3.org/TR/webauthn-2/#sctn-rp-operations). Attestation
+// statements are not evaluated: we ask browsers for "none" attestation and
+// trust a credential because the signed-in user registered it, not because
+// of its make or model.
+
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+var webauthnEncoding = base64.RawURLEncoding
+
+// decodeWebAuthnBase64 accepts both the URL and the standard alphabet, with
+// or without padding, as browser helpers differ.
+func decodeWebAuthnBase64(s string) ([]byte, error) {
+	s = string(bytes.TrimRight([]byte(s), "="))
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(s)
+	}
+	return b, err
+}
+
+type webauthnRelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *webauthnRelyingParty) checkClientData(raw []byte, ceremony, challenge string) error {
+	var cd collectedClientData
+	err := json.Unmarshal(raw, &cd)
+	if err != nil {
+		return errors.New("malformed client data")
+	}
+
+	if cd.Type != ceremony {
+		return fmt.Errorf("client data type %q, want %q", cd.Type, ceremony)
+	}
+
+	got, err := decodeWebAuthnBase64(cd.Challenge)
+	if err != nil {
+		return errors.New("malformed challenge")
+	}
+	want, err := decodeWebAuthnBase64(challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, want) != 1 {
+		return errors.New("challenge mismatch")
+	}
+
+	if cd.CrossOrigin {
+		return errors.New("cross-origin ceremonies are not allowed")
+	}
+
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q not allowed", cd.Origin)
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, only in registration responses
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if ad.Flags&authDataAttested == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("credential ID truncated")
+	}
+	ad.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	d := &cborDecoder{data: rest}
+	_, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("credential public key: %w", err)
+	}
+	ad.PublicKey = rest[:d.pos]
+
+	return ad, nil
+}
+
+func (rp *webauthnRelyingParty) checkAuthenticatorData(ad *authenticatorData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.RPIDHash, want[:]) != 1 {
+		return errors.New("relying party ID mismatch")
+	}
+
+	if ad.Flags&authDataUserPresent == 0 {
+		return errors.New("user not present")
+	}
+
+	// Passkeys replace the password, so the authenticator has to have
+	// verified the user (PIN, biometrics) as well.
+	if ad.Flags&authDataUserVerified == 0 {
+		return errors.New("user not verified")
+	}
+
+	return nil
+}
+
+// verifyRegistration checks a navigator.credentials.create() response and
+// returns the new credential's ID, COSE public key and signature counter.
+func (rp *webauthnRelyingParty) verifyRegistration(clientDataJSON, attestationObject []byte, challenge string) (*authenticatorData, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &cborDecoder{data: attestationObject}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("attestation object: %w", err)
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	raw, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object without authData")
+	}
+
+	ad, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rp.checkAuthenticatorData(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.CredentialID == nil {
+		return nil, errors.New("no attested credential")
+	}
+
+	_, err = parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ad, nil
+}
+
+// verifyAssertion checks a navigator.credentials.get() response against the
+// stored COSE public key and returns the authenticator's new counter.
+func (rp *webauthnRelyingParty) verifyAssertion(publicKey, clientDataJSON, rawAuthData, signature []byte, challenge string) (*authenticatorData, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rp.checkAuthenticatorData(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+
+	if !key.verify(signed, signature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return ad, nil
+}
+
+// signCountRegressed reports whether an assertion's counter failed to grow
+// past the stored one, which means two authenticators share the key, i.e.
+// it was cloned. Authenticators that keep no counter always send 0.
+func signCountRegressed(stored, got uint32) bool {
+	return (got != 0 || stored != 0) && got <= stored
+}
+
+type coseKey struct {
+	alg     int64
+	ecdsa   *ecdsa.PublicKey
+	ed25519 ed25519.PublicKey
+	rsa     *rsa.PublicKey
+}
+
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("COSE key: %w", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("COSE key is not a map")
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	key := &coseKey{alg: alg}
+
+	switch {
+	case kty == 2 && alg == coseAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("unsupported EC2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC2 key not on curve")
+		}
+		key.ecdsa = pub
+
+	case kty == 1 && alg == coseAlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported OKP key")
+		}
+		key.ed25519 = ed25519.PublicKey(x)
+
+	case kty == 3 && alg == coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("unsupported RSA key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		key.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+
+	default:
+		return nil, fmt.Errorf("unsupported COSE key type %d with algorithm %d", kty, alg)
+	}
+
+	return key, nil
+}
+
+func (k *coseKey) verify(message, signature []byte) bool {
+	switch {
+	case k.ecdsa != nil:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(k.ecdsa, digest[:], signature)
+	case k.ed25519 != nil:
+		return ed25519.Verify(k.ed25519, message, signature)
+	case k.rsa != nil:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// cborDecoder reads the subset of CBOR (RFC 8949) found in WebAuthn
+// structures. Integers decode as int64, maps as map[interface{}]interface{}.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+const cborMaxDepth = 16
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errors.New("unexpected end of CBOR data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	}
+	return 0, errors.New("indefinite-length CBOR is not supported")
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("CBOR nested too deeply")
+	}
+
+	head, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := head[0]>>5, head[0]&0x1f
+
+	if major == 7 {
+		return d.simple(info)
+	}
+
+	arg, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("CBOR integer overflow")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("CBOR integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.data)) {
+			return nil, errors.New("unexpected end of CBOR data")
+		}
+		b, err := d.next(int(arg))
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	case 4:
+		if arg > uint64(len(d.data)) {
+			return nil, errors.New("CBOR array too long")
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)) {
+			return nil, errors.New("CBOR map too long")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("unsupported CBOR map key")
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6:
+		// Tags carry no meaning in WebAuthn structures; use the content.
+		return d.value(depth + 1)
+	}
+
+	return nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
+
+func (d *cborDecoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+const testOrigin = "http://localhost:3000"
+
+func testRelyingParty() *webauthnRelyingParty {
+	return &webauthnRelyingParty{ID: "localhost", Name: "Study Threads", Origins: []string{testOrigin}}
+}
+
+// cborEncode writes the values a software authenticator needs: integers,
+// byte and text strings, and maps with integer or text keys.
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return string(cborEncode(keys[i])) < string(cborEncode(keys[j]))
+		})
+		b := head(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(v[k])...)
+		}
+		return b
+	}
+	panic("cborEncode: unsupported type")
+}
+
+// softAuthenticator plays the browser and authenticator side of a ceremony
+// with a generated ES256 or Ed25519 key.
+type softAuthenticator struct {
+	rpID      string
+	origin    string
+	flags     byte
+	signCount uint32
+	id        []byte
+	es256     *ecdsa.PrivateKey
+	ed25519   ed25519.PrivateKey
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	t.Helper()
+
+	a := &softAuthenticator{
+		rpID:   "localhost",
+		origin: testOrigin,
+		flags:  authDataUserPresent | authDataUserVerified,
+		id:     []byte("credential-" + t.Name()),
+	}
+
+	var err error
+	switch alg {
+	case coseAlgES256:
+		a.es256, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case coseAlgEdDSA:
+		_, a.ed25519, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.es256 != nil {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.es256.X.FillBytes(x)
+		a.es256.Y.FillBytes(y)
+		return cborEncode(map[interface{}]interface{}{
+			1: 2, 3: coseAlgES256, -1: 1, -2: x, -3: y,
+		})
+	}
+	return cborEncode(map[interface{}]interface{}{
+		1: 1, 3: coseAlgEdDSA, -1: 6, -2: []byte(a.ed25519.Public().(ed25519.PublicKey)),
+	})
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= authDataAttested
+	}
+
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...) // AAGUID
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.id)))
+		b = append(b, a.id...)
+		b = append(b, a.coseKey()...)
+	}
+	return b
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(collectedClientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (a *softAuthenticator) create(t *testing.T, challenge string) (clientData, attestation []byte) {
+	t.Helper()
+
+	clientData = a.clientData(t, "webauthn.create", challenge)
+	attestation = cborEncode(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(true),
+	})
+	return clientData, attestation
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge string) (clientData, authData, signature []byte) {
+	t.Helper()
+
+	a.signCount++
+	clientData = a.clientData(t, "webauthn.get", challenge)
+	authData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	var err error
+	if a.es256 != nil {
+		digest := sha256.Sum256(signed)
+		signature, err = ecdsa.SignASN1(rand.Reader, a.es256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		signature = ed25519.Sign(a.ed25519, signed)
+	}
+	return clientData, authData, signature
+}
+
+func newChallenge(t *testing.T) string {
+	t.Helper()
+
+	b, err := randomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webauthnEncoding.EncodeToString(b)
+}
+
+func register(t *testing.T, rp *webauthnRelyingParty, a *softAuthenticator) *authenticatorData {
+	t.Helper()
+
+	challenge := newChallenge(t)
+	clientData, attestation := a.create(t, challenge)
+	ad, err := rp.verifyRegistration(clientData, attestation, challenge)
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return ad
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		alg  int
+	}{
+		{"ES256", coseAlgES256},
+		{"Ed25519", coseAlgEdDSA},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rp := testRelyingParty()
+			a := newSoftAuthenticator(t, tc.alg)
+
+			ad := register(t, rp, a)
+			if string(ad.CredentialID) != string(a.id) {
+				t.Errorf("credential ID %q, want %q", ad.CredentialID, a.id)
+			}
+			stored := ad.SignCount
+
+			for i := 0; i < 2; i++ {
+				challenge := newChallenge(t)
+				clientData, authData, signature := a.get(t, challenge)
+				got, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+				if err != nil {
+					t.Fatalf("assertion %d: %v", i, err)
+				}
+				if got.SignCount != a.signCount {
+					t.Errorf("assertion %d: sign count %d, want %d", i, got.SignCount, a.signCount)
+				}
+				if signCountRegressed(stored, got.SignCount) {
+					t.Errorf("assertion %d: sign count %d after %d reported as regressed", i, got.SignCount, stored)
+				}
+				stored = got.SignCount
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(a *softAuthenticator)
+		want   string
+	}{
+		{"wrong RP ID hash", func(a *softAuthenticator) { a.rpID = "evil.example" }, "relying party ID mismatch"},
+		{"wrong origin", func(a *softAuthenticator) { a.origin = "https://evil.example" }, "not allowed"},
+		{"user not verified", func(a *softAuthenticator) { a.flags &^= authDataUserVerified }, "user not verified"},
+		{"user not present", func(a *softAuthenticator) { a.flags &^= authDataUserPresent }, "user not present"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t, coseAlgES256)
+			tc.modify(a)
+
+			challenge := newChallenge(t)
+			clientData, attestation := a.create(t, challenge)
+			_, err := testRelyingParty().verifyRegistration(clientData, attestation, challenge)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestWebAuthnAssertionRejects(t *testing.T) {
+	rp := testRelyingParty()
+
+	t.Run("wrong RP ID hash", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgES256)
+		ad := register(t, rp, a)
+		a.rpID = "evil.example"
+
+		challenge := newChallenge(t)
+		clientData, authData, signature := a.get(t, challenge)
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+		if err == nil || !strings.Contains(err.Error(), "relying party ID mismatch") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgEdDSA)
+		ad := register(t, rp, a)
+		a.origin = "http://localhost:3001"
+
+		challenge := newChallenge(t)
+		clientData, authData, signature := a.get(t, challenge)
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+		if err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("user not verified", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgES256)
+		ad := register(t, rp, a)
+		a.flags &^= authDataUserVerified
+
+		challenge := newChallenge(t)
+		clientData, authData, signature := a.get(t, challenge)
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+		if err == nil || !strings.Contains(err.Error(), "user not verified") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgES256)
+		ad := register(t, rp, a)
+
+		// A response captured for an earlier ceremony does not answer a
+		// fresh challenge.
+		old := newChallenge(t)
+		clientData, authData, signature := a.get(t, old)
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, newChallenge(t))
+		if err == nil || !strings.Contains(err.Error(), "challenge mismatch") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("registration response as assertion", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgES256)
+		ad := register(t, rp, a)
+
+		challenge := newChallenge(t)
+		_, authData, signature := a.get(t, challenge)
+		clientData := a.clientData(t, "webauthn.create", challenge)
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+		if err == nil || !strings.Contains(err.Error(), "client data type") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("tampered authenticator data", func(t *testing.T) {
+		a := newSoftAuthenticator(t, coseAlgEdDSA)
+		ad := register(t, rp, a)
+
+		challenge := newChallenge(t)
+		clientData, authData, signature := a.get(t, challenge)
+		authData[36]++ // bump the signed counter
+		_, err := rp.verifyAssertion(ad.PublicKey, clientData, authData, signature, challenge)
+		if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Errorf("got error %v", err)
+		}
+	})
+}
+
+func TestSignCountRegressed(t *testing.T) {
+	for _, tc := range []struct {
+		stored, got uint32
+		want        bool
+	}{
+		{0, 0, false}, // authenticator keeps no counter
+		{0, 1, false},
+		{5, 6, false},
+		{5, 5, true}, // replayed or cloned
+		{5, 4, true},
+		{5, 0, true}, // a counter never resets
+	} {
+		if got := signCountRegressed(tc.stored, tc.got); got != tc.want {
+			t.Errorf("signCountRegressed(%d, %d) = %t, want %t", tc.stored, tc.got, got, tc.want)
+		}
+	}
+}
+
+// newTestApplication returns an application that can sign and check
+// tokens, but has no database.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{}
+	app.config.jwt.issuer = "study-threads"
+	app.keys = &keySet{}
+	err := app.keys.add(&signingKey{ID: "test", Algorithm: jwt.HS256, secret: []byte(strings.Repeat("s", minSecretLength))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.keys.signing = app.keys.keys[0]
+	return app
+}
+
+func TestCeremonyTokenExpiry(t *testing.T) {
+	app := newTestApplication(t)
+
+	challenge, token, err := app.beginCeremony("passkey-login", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := app.checkPurposeToken(token, "passkey-login")
+	if err != nil {
+		t.Fatalf("fresh ceremony token: %v", err)
+	}
+	if got, _ := claims.String("challenge"); got != challenge {
+		t.Errorf("challenge %q, want %q", got, challenge)
+	}
+
+	_, err = app.checkPurposeToken(token, "passkey-registration")
+	if err == nil {
+		t.Error("ceremony token accepted for another purpose")
+	}
+
+	stale, err := app.signPurposeToken("passkey-login", "", map[string]interface{}{"challenge": challenge}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.checkPurposeToken(stale, "passkey-login")
+	if err == nil || err.Error() != "token expired" {
+		t.Errorf("stale ceremony token: got error %v, want token expired", err)
+	}
+}
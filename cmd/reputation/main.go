@@ -0,0 +1,70 @@
+// Command reputation recomputes every user's reputation from the ledger in
+// reputation_events and reports accounts whose stored total disagrees.
+// Without -fix it only reports, exiting with status 1 when drift is found.
+//
+//	go run ./cmd/reputation -fix
+package main
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var (
+		dsn string
+		fix bool
+	)
+
+	flag.StringVar(&dsn, "dsn", "host=localhost port=5432 user=postgres password= dbname=go_threads sslmode=disable", "Postgres connection string")
+	flag.BoolVar(&fix, "fix", false, "Overwrite drifted totals with the ledger's")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "", 0)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		logger.Fatal(err)
+	}
+
+	m := models.NewModels(db)
+	drifts, err := m.DB.ReconcileReputation(fix)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if len(drifts) == 0 {
+		fmt.Fprintln(os.Stderr, "all reputation totals match the ledger")
+		return
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "user_id\tusername\tstored\tledger")
+	for _, d := range drifts {
+		fmt.Fprintf(out, "%d\t%s\t%d\t%d\n", d.UserID, d.Username, d.Stored, d.Ledger)
+	}
+	out.Flush()
+
+	if fix {
+		fmt.Fprintf(os.Stderr, "%d totals fixed\n", len(drifts))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d totals drifted, run with -fix to correct them\n", len(drifts))
+	os.Exit(1)
+}
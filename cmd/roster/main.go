@@ -0,0 +1,100 @@
+// Command roster imports a class roster CSV (name,email[,role]) into the
+// database, the same way POST /v1/admin/roster does, and writes a CSV report
+// with each student's activation link to stdout.
+//
+//	go run ./cmd/roster -file students.csv > links.csv
+package main
+
+import (
+	"backend/models"
+	"backend/roster"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var (
+		dsn           string
+		file          string
+		frontendURL   string
+		activationTTL time.Duration
+	)
+
+	flag.StringVar(&dsn, "dsn", "host=localhost port=5432 user=postgres password= dbname=go_threads sslmode=disable", "Postgres connection string")
+	flag.StringVar(&file, "file", "-", "Roster CSV, - for stdin")
+	flag.StringVar(&frontendURL, "frontend-url", "http://localhost:3000", "Base URL of the frontend the activation links point to")
+	flag.DurationVar(&activationTTL, "activation-ttl", 14*24*time.Hour, "How long activation links stay valid")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "", 0)
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	rows, failed, err := roster.Parse(in)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		logger.Fatal(err)
+	}
+
+	m := models.NewModels(db)
+	importer := &roster.Importer{
+		DB:            &m.DB,
+		FrontendURL:   frontendURL,
+		ActivationTTL: activationTTL,
+	}
+
+	results := append(importer.Import(rows), failed...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Line < results[j].Line })
+
+	out := csv.NewWriter(os.Stdout)
+	out.Write([]string{"line", "email", "status", "user_id", "username", "activation_url", "error"})
+	for _, r := range results {
+		userID := ""
+		if r.UserID != 0 {
+			userID = strconv.Itoa(r.UserID)
+		}
+		out.Write([]string{strconv.Itoa(r.Line), r.Email, r.Status, userID, r.Username, r.ActivationURL, r.Error})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		logger.Fatal(err)
+	}
+
+	summary := roster.Summary(results)
+	fmt.Fprintf(os.Stderr, "%d created, %d updated, %d unchanged, %d failed\n",
+		summary[roster.StatusCreated], summary[roster.StatusUpdated], summary[roster.StatusUnchanged], summary[roster.StatusFailed])
+
+	if summary[roster.StatusFailed] > 0 {
+		os.Exit(1)
+	}
+}
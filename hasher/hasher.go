@@ -0,0 +1,182 @@
+// Package hasher hashes and verifies user passwords. Every hash records its
+// algorithm and cost, so the policy for new hashes can change while old
+// hashes keep verifying until they are upgraded on the next sign-in.
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownFormat is returned for hashes no configured Hasher recognizes,
+// including the empty password of accounts that sign in another way.
+var ErrUnknownFormat = errors.New("hasher: unknown hash format")
+
+type Hasher interface {
+	// Hash encodes password with a fresh salt.
+	Hash(password string) (string, error)
+	// Recognizes reports whether encoded was produced by this algorithm.
+	Recognizes(encoded string) bool
+	// Verify reports whether password matches encoded.
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether encoded was hashed with weaker or
+	// different parameters than the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// Policy hashes new passwords with Current and verifies hashes made by
+// Current or any of Legacy.
+type Policy struct {
+	Current Hasher
+	Legacy  []Hasher
+}
+
+func (p *Policy) Hash(password string) (string, error) {
+	return p.Current.Hash(password)
+}
+
+// Verify checks password against encoded. When it matches and encoded does
+// not satisfy the current policy, rehash is true and the caller should store
+// a new Hash of the password.
+func (p *Policy) Verify(encoded, password string) (match, rehash bool, err error) {
+	if p.Current.Recognizes(encoded) {
+		match, err = p.Current.Verify(encoded, password)
+		return match, match && p.Current.NeedsRehash(encoded), err
+	}
+
+	for _, h := range p.Legacy {
+		if h.Recognizes(encoded) {
+			match, err = h.Verify(encoded, password)
+			return match, match, err
+		}
+	}
+
+	return false, false, ErrUnknownFormat
+}
+
+// Bcrypt produces hashes in bcrypt's own "$2a$<cost>$..." format.
+type Bcrypt struct {
+	Cost int
+}
+
+func (h *Bcrypt) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(b), err
+}
+
+func (h *Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2")
+}
+
+func (h *Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2id produces PHC strings:
+//
+//	$argon2id$v=19$m=<memory KiB>,t=<time>,p=<threads>$<salt>$<key>
+//
+// with salt and key in unpadded standard base64.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (h *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownFormat
+	}
+
+	var p argon2Params
+	_, err := fmt.Sscanf(parts[2], "v=%d", &p.version)
+	if err != nil {
+		return nil, fmt.Errorf("hasher: argon2id version: %w", err)
+	}
+	if p.version != argon2.Version {
+		return nil, fmt.Errorf("hasher: unsupported argon2 version %d", p.version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil {
+		return nil, fmt.Errorf("hasher: argon2id parameters: %w", err)
+	}
+
+	p.salt, err = phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("hasher: argon2id salt: %w", err)
+	}
+	p.key, err = phcEncoding.DecodeString(parts[5])
+	if err != nil || len(p.key) == 0 {
+		return nil, errors.New("hasher: argon2id key malformed")
+	}
+
+	return &p, nil
+}
+
+func (h *Argon2id) Verify(encoded, password string) (bool, error) {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *Argon2id) NeedsRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory < h.Memory || p.time < h.Time || p.threads != h.Threads ||
+		uint32(len(p.key)) < h.KeyLen || len(p.salt) < h.SaltLen
+}
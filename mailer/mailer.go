@@ -0,0 +1,75 @@
+// Package mailer sends the account emails (password resets, verification
+// links) through SMTP, or writes them to a file or log during development.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP delivers mail through an SMTP relay, authenticating with PLAIN auth
+// when a username is set. Sender is the From header, possibly with a display
+// name; Envelope is the bare address given to the relay as MAIL FROM.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+	Envelope string
+}
+
+func (m *SMTP) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.Envelope, []string{msg.To}, format(m.Sender, msg))
+}
+
+// Writer appends every message to W instead of delivering it, which is what
+// development setups and tests want.
+type Writer struct {
+	W      io.Writer
+	Sender string
+
+	mu sync.Mutex
+}
+
+func (m *Writer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.W, "%s\n", format(m.Sender, msg))
+	return err
+}
+
+func format(sender string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", sender)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,13 @@
+CREATE TABLE sessions (
+    id                 bigserial PRIMARY KEY,
+    user_id            integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    refresh_token_hash bytea NOT NULL,
+    user_agent         text NOT NULL DEFAULT '',
+    ip                 text NOT NULL DEFAULT '',
+    created_at         timestamptz NOT NULL DEFAULT now(),
+    last_used_at       timestamptz NOT NULL DEFAULT now(),
+    expires_at         timestamptz NOT NULL,
+    revoked_at         timestamptz
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,18 @@
+-- Failed sign-in counters, keyed by "user:<username>" and "ip:<address>".
+CREATE TABLE login_failures (
+    key             text PRIMARY KEY,
+    failures        integer NOT NULL DEFAULT 0,
+    last_failure_at timestamptz NOT NULL DEFAULT now(),
+    locked_until    timestamptz
+);
+
+CREATE TABLE auth_events (
+    id         bigserial PRIMARY KEY,
+    event      text NOT NULL,
+    username   text NOT NULL DEFAULT '',
+    ip         text NOT NULL DEFAULT '',
+    detail     text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX auth_events_created_at_idx ON auth_events (created_at DESC);
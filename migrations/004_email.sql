@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN email text,
+    ADD COLUMN email_verified_at timestamptz;
+
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email));
+
+-- Single-use tokens mailed to users, stored as SHA-256 hashes.
+CREATE TABLE user_tokens (
+    hash       bytea PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    scope      text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_tokens_user_id_idx ON user_tokens (user_id, scope);
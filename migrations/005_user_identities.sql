@@ -0,0 +1,10 @@
+-- Accounts at external OpenID Connect providers linked to local users.
+CREATE TABLE user_identities (
+    issuer     text NOT NULL,
+    subject    text NOT NULL,
+    user_id    integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE personal_access_tokens (
+    id           bigserial PRIMARY KEY,
+    user_id      integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    name         text NOT NULL,
+    token_hash   bytea NOT NULL UNIQUE,
+    scopes       text[] NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    expires_at   timestamptz NOT NULL,
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id);
@@ -0,0 +1,20 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret text,
+    ADD COLUMN totp_enabled_at timestamptz,
+    ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    id         bigserial PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    code_hash  bytea NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+CREATE TABLE site_settings (
+    key        text PRIMARY KEY,
+    value      jsonb NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
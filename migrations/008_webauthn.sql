@@ -0,0 +1,14 @@
+-- Random, stable user handle passed to authenticators instead of the user ID.
+ALTER TABLE users ADD COLUMN webauthn_handle bytea UNIQUE;
+
+CREATE TABLE webauthn_credentials (
+    id           bytea PRIMARY KEY,
+    user_id      integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    public_key   bytea NOT NULL,
+    sign_count   bigint NOT NULL DEFAULT 0,
+    name         text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    last_used_at timestamptz
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
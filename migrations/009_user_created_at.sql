@@ -0,0 +1,4 @@
+-- Accounts that existed before this migration count as created now, which
+-- only matters for checks on brand-new accounts.
+ALTER TABLE users
+    ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,14 @@
+CREATE TABLE invitations (
+    id         bigserial PRIMARY KEY,
+    code_hash  bytea NOT NULL UNIQUE,
+    note       text NOT NULL DEFAULT '',
+    max_uses   integer NOT NULL CHECK (max_uses > 0),
+    uses       integer NOT NULL DEFAULT 0,
+    created_by integer REFERENCES users (user_id) ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz,
+    revoked_at timestamptz
+);
+
+ALTER TABLE users
+    ADD COLUMN invitation_id bigint REFERENCES invitations (id) ON DELETE SET NULL;
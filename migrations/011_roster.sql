@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN display_name text NOT NULL DEFAULT '';
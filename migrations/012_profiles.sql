@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN bio        text NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url text NOT NULL DEFAULT '';
+
+-- Usernames are looked up case-insensitively, so they must also be unique
+-- that way. Rename clashing accounts before running this.
+CREATE UNIQUE INDEX users_username_lower_idx ON users (lower(username));
@@ -0,0 +1,13 @@
+-- A scheduled deletion: the account is purged once purge_after has passed,
+-- unless the user cancels before.
+ALTER TABLE users
+    ADD COLUMN deletion_mode         text,
+    ADD COLUMN deletion_requested_at timestamptz,
+    ADD COLUMN purge_after           timestamptz;
+
+CREATE INDEX users_purge_after_idx ON users (purge_after) WHERE purge_after IS NOT NULL;
+
+-- Anonymized threads and replies outlive their author's row and fall back
+-- to author_name.
+ALTER TABLE threads DROP CONSTRAINT IF EXISTS threads_author_id_fkey;
+ALTER TABLE replies DROP CONSTRAINT IF EXISTS replies_author_id_fkey;
@@ -0,0 +1,7 @@
+-- Settings missing from the document, e.g. ones added later, fall back to
+-- models.DefaultUserSettings.
+CREATE TABLE user_settings (
+    user_id    integer PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    settings   jsonb NOT NULL DEFAULT '{}',
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
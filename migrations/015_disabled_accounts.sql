@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN disabled_at     timestamptz,
+    ADD COLUMN disabled_reason text NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+-- One vote per user and thread or reply. threads.upvotes and replies.score
+-- hold the sum of the votes and are kept up to date by models.SetVote.
+CREATE TABLE votes (
+    user_id    integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    thread_id  integer REFERENCES threads (id) ON DELETE CASCADE,
+    reply_id   integer REFERENCES replies (id) ON DELETE CASCADE,
+    value      smallint NOT NULL CHECK (value IN (-1, 1)),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CHECK ((thread_id IS NULL) <> (reply_id IS NULL))
+);
+
+CREATE UNIQUE INDEX votes_thread_idx ON votes (user_id, thread_id) WHERE thread_id IS NOT NULL;
+CREATE UNIQUE INDEX votes_reply_idx ON votes (user_id, reply_id) WHERE reply_id IS NOT NULL;
+
+ALTER TABLE replies ADD COLUMN score integer NOT NULL DEFAULT 0;
@@ -0,0 +1,45 @@
+-- Append-only reputation ledger. Undoing an event (a withdrawn vote, an
+-- unaccepted answer, deleted content) adds a row with the negated points
+-- that references it in reverses. users.reputation is the running sum and
+-- can be rebuilt from this table with cmd/reputation.
+CREATE TABLE reputation_events (
+    id          bigserial PRIMARY KEY,
+    user_id     integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    event       text NOT NULL,
+    points      integer NOT NULL,
+    source_type text NOT NULL,
+    source_id   integer NOT NULL,
+    actor_id    integer,
+    reverses    bigint UNIQUE REFERENCES reputation_events (id) ON DELETE CASCADE,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX reputation_events_user_idx ON reputation_events (user_id, created_at);
+CREATE INDEX reputation_events_source_idx ON reputation_events (source_type, source_id);
+
+ALTER TABLE users ADD COLUMN reputation integer NOT NULL DEFAULT 0;
+
+-- Credit what happened before the ledger existed, without daily caps.
+INSERT INTO reputation_events (user_id, event, points, source_type, source_id, actor_id, created_at)
+SELECT r.author_id, 'answer_accepted', 15, 'reply', r.id, t.author_id, r.created_at
+FROM replies r
+JOIN threads t ON t.id = r.thread_id
+JOIN users u ON u.user_id = r.author_id
+WHERE r.is_answer AND r.author_id <> t.author_id;
+
+INSERT INTO reputation_events (user_id, event, points, source_type, source_id, actor_id, created_at)
+SELECT t.author_id, 'thread_solved', 2, 'thread', t.id, t.author_id, t.updated_at
+FROM threads t
+JOIN users u ON u.user_id = t.author_id
+WHERE t.is_solved;
+
+INSERT INTO reputation_events (user_id, event, points, source_type, source_id, actor_id, created_at)
+SELECT r.author_id, 'reply_upvoted', 10, 'reply', r.id, v.user_id, v.created_at
+FROM votes v
+JOIN replies r ON r.id = v.reply_id
+JOIN users u ON u.user_id = r.author_id
+WHERE v.value = 1;
+
+UPDATE users u SET reputation = s.total
+FROM (SELECT user_id, sum(points) AS total FROM reputation_events GROUP BY user_id) s
+WHERE u.user_id = s.user_id;
@@ -0,0 +1,10 @@
+-- Badges a user has earned. The badges themselves are defined in code, see
+-- package badges; badge_id is their ID there.
+CREATE TABLE user_badges (
+    user_id    integer NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    badge_id   text NOT NULL,
+    awarded_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, badge_id)
+);
+
+CREATE INDEX user_badges_badge_idx ON user_badges (badge_id, awarded_at);
@@ -0,0 +1,8 @@
+-- Replies may answer another reply of the same thread. A deleted reply that
+-- still has replies of its own stays behind as a tombstone (deleted_at set,
+-- content cleared) so the tree below it keeps its place.
+ALTER TABLE replies
+    ADD COLUMN parent_id  integer REFERENCES replies (id) ON DELETE SET NULL,
+    ADD COLUMN deleted_at timestamptz;
+
+CREATE INDEX replies_parent_idx ON replies (parent_id) WHERE parent_id IS NOT NULL;
@@ -0,0 +1,14 @@
+-- Every version of a reply that was replaced by an edit. The current
+-- version stays in replies; revision numbers count from 1, the original.
+ALTER TABLE replies
+    ADD COLUMN edited_at timestamptz,
+    ADD COLUMN edited_by integer;
+
+CREATE TABLE reply_revisions (
+    reply_id   integer NOT NULL REFERENCES replies (id) ON DELETE CASCADE,
+    revision   integer NOT NULL,
+    content    text NOT NULL,
+    editor_id  integer,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (reply_id, revision)
+);
@@ -0,0 +1,9 @@
+-- Accounts a roster import created and nobody has activated yet. Only these
+-- may be matched by an unverified email when the roster is imported again.
+ALTER TABLE users
+    ADD COLUMN rostered boolean NOT NULL DEFAULT false;
+
+-- Activation tokens are only issued to roster accounts.
+UPDATE users SET rostered = true
+    WHERE email_verified_at IS NULL
+      AND user_id IN (SELECT user_id FROM user_tokens WHERE scope = 'activation');
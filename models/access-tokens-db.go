@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (m *DBModel) InsertAccessToken(token *PersonalAccessToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+             VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// GetAccessTokenByHash returns a live token together with its owner's role.
+func (m *DBModel) GetAccessTokenByHash(hash []byte) (*PersonalAccessToken, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT t.id, t.user_id, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at, u.role
+              FROM personal_access_tokens t
+              JOIN users u ON u.user_id = t.user_id
+              WHERE t.token_hash = $1 AND t.revoked_at IS NULL AND t.expires_at > now() AND u.disabled_at IS NULL`
+
+	var token PersonalAccessToken
+	var role string
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&role,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, role, nil
+}
+
+// TouchAccessToken records a use of the token, at most once a minute.
+func (m *DBModel) TouchAccessToken(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE personal_access_tokens SET last_used_at = now()
+             WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) AccessTokensForUser(userID int) ([]*PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at, revoked_at
+              FROM personal_access_tokens
+              WHERE user_id = $1
+              ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*PersonalAccessToken
+	for rows.Next() {
+		var token PersonalAccessToken
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			pq.Array(&token.Scopes),
+			&token.CreatedAt,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+			&token.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, nil
+}
+
+func (m *DBModel) RevokeAccessToken(id int64, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE personal_access_tokens SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
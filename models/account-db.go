@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// RepliesByAuthor returns every reply the user wrote, newest first.
+func (m *DBModel) RepliesByAuthor(authorID int) ([]*Reply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT r.id, r.thread_id, r.parent_id, r.content, r.author_id, COALESCE(u.username, r.author_name), r.created_at, r.is_answer, r.score
+              FROM replies r
+              LEFT JOIN users u ON u.user_id = r.author_id
+              WHERE r.author_id = $1 AND r.deleted_at IS NULL
+              ORDER BY r.created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replies := []*Reply{}
+	for rows.Next() {
+		var reply Reply
+		err := rows.Scan(&reply.ID, &reply.ThreadID, &reply.ParentID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer, &reply.Score)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, &reply)
+	}
+
+	return replies, rows.Err()
+}
+
+// VotesByUser returns every vote the user cast, newest first.
+func (m *DBModel) VotesByUser(userID int) ([]*Vote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT thread_id, reply_id, value, created_at
+              FROM votes
+              WHERE user_id = $1
+              ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := []*Vote{}
+	for rows.Next() {
+		var vote Vote
+		err := rows.Scan(&vote.ThreadID, &vote.ReplyID, &vote.Value, &vote.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		votes = append(votes, &vote)
+	}
+
+	return votes, rows.Err()
+}
+
+func (m *DBModel) ScheduleAccountDeletion(userID int, mode string, purgeAfter time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET deletion_mode = $1, deletion_requested_at = now(), purge_after = $2 WHERE user_id = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, mode, purgeAfter, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// CancelAccountDeletion returns sql.ErrNoRows when no deletion was pending.
+func (m *DBModel) CancelAccountDeletion(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET deletion_mode = NULL, deletion_requested_at = NULL, purge_after = NULL
+             WHERE user_id = $1 AND purge_after IS NOT NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+const accountDeletionColumns = `user_id, username, deletion_mode, deletion_requested_at, purge_after`
+
+func scanAccountDeletion(row rowScanner) (*AccountDeletion, error) {
+	var d AccountDeletion
+	err := row.Scan(&d.UserID, &d.Username, &d.Mode, &d.RequestedAt, &d.PurgeAfter)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetAccountDeletion returns the user's pending deletion, or sql.ErrNoRows.
+func (m *DBModel) GetAccountDeletion(userID int) (*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + ` FROM users WHERE user_id = $1 AND purge_after IS NOT NULL`
+
+	return scanAccountDeletion(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+// DueAccountDeletions lists deletions whose grace period is over.
+func (m *DBModel) DueAccountDeletions(limit int) ([]*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + ` FROM users
+              WHERE purge_after <= now() ORDER BY purge_after LIMIT $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*AccountDeletion
+	for rows.Next() {
+		d, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+
+	return due, rows.Err()
+}
+
+// PurgeUser deletes the account with everything that hangs off it. Its
+// threads and replies are either removed, replies of others to its threads
+// included, or kept under DeletedAuthorName. The deletion must still be
+// pending, so a cancellation that raced the purger wins.
+func (m *DBModel) PurgeUser(userID int, mode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username string
+	err = tx.QueryRowContext(ctx, `SELECT username FROM users WHERE user_id = $1 AND purge_after <= now() FOR UPDATE`, userID).Scan(&username)
+	if err != nil {
+		return err
+	}
+
+	// Votes the user cast go away with the account, and so does what they
+	// earned others.
+	err = reverseReputation(ctx, tx, `e.actor_id = $1 AND e.user_id <> $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	var stmts []string
+	if mode == DeletionRemove {
+		// Others' replies under the user's threads are removed with them,
+		// as in DeleteThread, and so is what they earned.
+		err = reverseReputation(ctx, tx, `(e.source_type = 'thread' AND e.source_id IN (SELECT id FROM threads WHERE author_id = $1))
+                                      OR (e.source_type = 'reply' AND e.source_id IN (SELECT r.id FROM replies r JOIN threads t ON t.id = r.thread_id WHERE t.author_id = $1))`, userID)
+		if err != nil {
+			return err
+		}
+
+		stmts = []string{
+			`DELETE FROM starred_threads WHERE thread_id IN (SELECT id FROM threads WHERE author_id = $1)`,
+			// Replies with replies by others below them stay as tombstones.
+			`WITH RECURSIVE kept AS (
+                 SELECT parent_id AS id FROM replies WHERE author_id <> $1 AND parent_id IS NOT NULL
+                 UNION
+                 SELECT r.parent_id FROM replies r JOIN kept k ON r.id = k.id WHERE r.parent_id IS NOT NULL
+             )
+             UPDATE replies SET content = '', author_name = '` + DeletedAuthorName + `', is_answer = false, score = 0, deleted_at = COALESCE(deleted_at, now())
+             WHERE author_id = $1 AND id IN (SELECT id FROM kept)
+               AND thread_id NOT IN (SELECT id FROM threads WHERE author_id = $1)`,
+			`DELETE FROM reply_revisions WHERE reply_id IN (SELECT id FROM replies WHERE author_id = $1)`,
+			`DELETE FROM replies WHERE (author_id = $1 AND deleted_at IS NULL) OR thread_id IN (SELECT id FROM threads WHERE author_id = $1)`,
+			`DELETE FROM threads_categories WHERE thread_id IN (SELECT id FROM threads WHERE author_id = $1)`,
+			`DELETE FROM threads WHERE author_id = $1`,
+		}
+	} else {
+		stmts = []string{
+			`UPDATE threads SET author_name = '` + DeletedAuthorName + `' WHERE author_id = $1`,
+			`UPDATE replies SET author_name = '` + DeletedAuthorName + `' WHERE author_id = $1`,
+		}
+	}
+	stmts = append(stmts,
+		`UPDATE threads t SET upvotes = t.upvotes - v.value FROM votes v WHERE v.thread_id = t.id AND v.user_id = $1`,
+		`UPDATE replies r SET score = r.score - v.value FROM votes v WHERE v.reply_id = r.id AND v.user_id = $1`,
+		`DELETE FROM votes WHERE user_id = $1`,
+		`DELETE FROM starred_threads WHERE user_id = $1`,
+		`DELETE FROM users WHERE user_id = $1`,
+	)
+
+	for _, stmt := range stmts {
+		_, err = tx.ExecContext(ctx, stmt, userID)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE key = 'user:' || lower($1)`, username)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return tx.Commit()
+}
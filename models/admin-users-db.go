@@ -0,0 +1,189 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// userActivityLateral computes per-user activity for the user aliased u.
+const userActivityLateral = `CROSS JOIN LATERAL (
+                  SELECT GREATEST(
+                             (SELECT max(s.last_used_at) FROM sessions s WHERE s.user_id = u.user_id),
+                             (SELECT max(t.created_at) FROM threads t WHERE t.author_id = u.user_id),
+                             (SELECT max(r.created_at) FROM replies r WHERE r.author_id = u.user_id)
+                         ) AS last_active_at,
+                         (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id) AS threads,
+                         (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.deleted_at IS NULL) AS replies
+              ) a`
+
+var userSearchOrder = map[string]string{
+	"joined":      "u.created_at DESC, u.user_id DESC",
+	"username":    "lower(u.username), u.user_id",
+	"last_active": "a.last_active_at DESC NULLS LAST, u.user_id DESC",
+	"threads":     "a.threads DESC, u.user_id DESC",
+	"replies":     "a.replies DESC, u.user_id DESC",
+}
+
+// ValidUserSort reports whether sort is an order SearchUsers knows.
+func ValidUserSort(sort string) bool {
+	_, ok := userSearchOrder[sort]
+	return ok
+}
+
+// SearchUsers returns a page of users matching s and the number of users
+// matching in total.
+func (m *DBModel) SearchUsers(s UserSearch) ([]*AdminUser, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if s.Query != "" {
+		p := arg("%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s.Query) + "%")
+		conditions = append(conditions, fmt.Sprintf("(u.username ILIKE %[1]s OR u.display_name ILIKE %[1]s OR u.email ILIKE %[1]s)", p))
+	}
+	if s.Role != "" {
+		conditions = append(conditions, "u.role = "+arg(s.Role))
+	}
+	if !s.JoinedAfter.IsZero() {
+		conditions = append(conditions, "u.created_at >= "+arg(s.JoinedAfter))
+	}
+	if !s.JoinedBefore.IsZero() {
+		conditions = append(conditions, "u.created_at < "+arg(s.JoinedBefore))
+	}
+	if !s.ActiveSince.IsZero() {
+		conditions = append(conditions, "a.last_active_at >= "+arg(s.ActiveSince))
+	}
+	if !s.InactiveSince.IsZero() {
+		conditions = append(conditions, "(a.last_active_at IS NULL OR a.last_active_at < "+arg(s.InactiveSince)+")")
+	}
+	if s.Disabled != nil {
+		if *s.Disabled {
+			conditions = append(conditions, "u.disabled_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "u.disabled_at IS NULL")
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	order, ok := userSearchOrder[s.Sort]
+	if !ok {
+		order = userSearchOrder["joined"]
+	}
+
+	query := `SELECT u.user_id, u.username, u.display_name, COALESCE(u.email, ''), u.role, u.created_at, u.disabled_at,
+                     a.last_active_at, a.threads, a.replies, count(*) OVER ()
+              FROM users u
+              ` + userActivityLateral + `
+              ` + where + `
+              ORDER BY ` + order + `
+              LIMIT ` + arg(s.Limit) + ` OFFSET ` + arg(s.Offset)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*AdminUser{}
+	total := 0
+	for rows.Next() {
+		var u AdminUser
+		err := rows.Scan(
+			&u.UserID,
+			&u.Username,
+			&u.DisplayName,
+			&u.Email,
+			&u.Role,
+			&u.CreatedAt,
+			&u.DisabledAt,
+			&u.LastActiveAt,
+			&u.Threads,
+			&u.Replies,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &u)
+	}
+
+	return users, total, rows.Err()
+}
+
+func (m *DBModel) GetUserActivity(userID int) (*UserActivity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT a.last_active_at,
+                     (SELECT max(s.created_at) FROM sessions s WHERE s.user_id = u.user_id),
+                     (SELECT count(*) FROM sessions s WHERE s.user_id = u.user_id AND s.revoked_at IS NULL AND s.expires_at > now()),
+                     (SELECT count(*) FROM personal_access_tokens p WHERE p.user_id = u.user_id AND p.revoked_at IS NULL AND p.expires_at > now()),
+                     (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id AND t.created_at > now() - interval '30 days'),
+                     (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.deleted_at IS NULL AND r.created_at > now() - interval '30 days')
+              FROM users u
+              ` + userActivityLateral + `
+              WHERE u.user_id = $1`
+
+	var a UserActivity
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&a.LastActiveAt,
+		&a.LastSignInAt,
+		&a.ActiveSessions,
+		&a.ActiveTokens,
+		&a.ThreadsLast30Days,
+		&a.RepliesLast30Days,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := m.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	a.Stats = profile.Stats
+
+	return &a, nil
+}
+
+// SetUserDisabled disables the account with reason, or enables it again
+// when disabled is false.
+func (m *DBModel) SetUserDisabled(userID int, disabled bool, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, now()) END, disabled_reason = $2
+             WHERE user_id = $3`
+	if !disabled {
+		reason = ""
+	}
+	result, err := m.DB.ExecContext(ctx, stmt, disabled, reason, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RecordLoginFailure counts a failed sign-in against key and returns the
+// number of failures seen within window.
+func (m *DBModel) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, now())
+             ON CONFLICT (key) DO UPDATE SET
+                failures = CASE WHEN login_failures.last_failure_at < now() - $2 * interval '1 second'
+                                THEN 1 ELSE login_failures.failures + 1 END,
+                last_failure_at = now()
+             RETURNING failures`
+
+	var failures int
+	err := m.DB.QueryRowContext(ctx, stmt, key, window.Seconds()).Scan(&failures)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (m *DBModel) LockLogin(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE login_failures SET locked_until = $1 WHERE key = $2`, until, key)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// LoginLockedUntil returns the latest lock among keys, or the zero time when
+// none of them is locked.
+func (m *DBModel) LoginLockedUntil(keys ...string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var until sql.NullTime
+	query := `SELECT max(locked_until) FROM login_failures WHERE key = ANY($1) AND locked_until > now()`
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&until)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until.Time, nil
+}
+
+func (m *DBModel) ClearLoginFailures(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) InsertAuthEvent(event AuthEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO auth_events (event, username, ip, detail) VALUES ($1, $2, $3, $4)`
+	_, err := m.DB.ExecContext(ctx, stmt, event.Event, event.Username, event.IP, event.Detail)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// AuthEvents returns the newest audit records, optionally only of one kind.
+func (m *DBModel) AuthEvents(event string, limit int) ([]*AuthEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, event, username, ip, detail, created_at
+              FROM auth_events
+              WHERE $1 = '' OR event = $1
+              ORDER BY created_at DESC
+              LIMIT $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, event, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuthEvent
+	for rows.Next() {
+		var e AuthEvent
+		err := rows.Scan(&e.ID, &e.Event, &e.Username, &e.IP, &e.Detail, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+func (m *DBModel) GetBadgeStats(userID int) (*BadgeStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id),
+                     (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.deleted_at IS NULL),
+                     (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.is_answer AND r.deleted_at IS NULL),
+                     (SELECT count(DISTINCT r.thread_id) FROM replies r WHERE r.author_id = u.user_id AND r.is_answer AND r.deleted_at IS NULL),
+                     (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id AND t.is_solved),
+                     (SELECT count(DISTINCT tc.category_id)
+                      FROM replies r
+                      JOIN threads t ON t.id = r.thread_id
+                      JOIN threads_categories tc ON tc.thread_id = t.id
+                      WHERE r.author_id = u.user_id AND r.deleted_at IS NULL AND t.author_id <> u.user_id),
+                     (SELECT count(*) FROM votes v JOIN threads t ON t.id = v.thread_id WHERE t.author_id = u.user_id AND v.value = 1)
+                   + (SELECT count(*) FROM votes v JOIN replies r ON r.id = v.reply_id WHERE r.author_id = u.user_id AND v.value = 1),
+                     u.reputation
+              FROM users u
+              WHERE u.user_id = $1`
+
+	var s BadgeStats
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&s.ThreadsAsked,
+		&s.RepliesPosted,
+		&s.AcceptedAnswers,
+		&s.ThreadsAnswered,
+		&s.SolvedThreads,
+		&s.CategoriesHelped,
+		&s.UpvotesReceived,
+		&s.Reputation,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// AwardBadge records that the user earned a badge and reports whether they
+// did not have it yet.
+func (m *DBModel) AwardBadge(userID int, badgeID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO user_badges (user_id, badge_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	result, err := m.DB.ExecContext(ctx, stmt, userID, badgeID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (m *DBModel) UserBadges(userID int) ([]*UserBadge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT b.badge_id, b.user_id, u.username, b.awarded_at
+              FROM user_badges b
+              JOIN users u ON u.user_id = b.user_id
+              WHERE b.user_id = $1
+              ORDER BY b.awarded_at`
+
+	return m.queryBadges(ctx, query, userID)
+}
+
+// BadgeHolders lists who earned a badge, earliest first.
+func (m *DBModel) BadgeHolders(badgeID string, limit, offset int) ([]*UserBadge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT b.badge_id, b.user_id, u.username, b.awarded_at
+              FROM user_badges b
+              JOIN users u ON u.user_id = b.user_id
+              WHERE b.badge_id = $1
+              ORDER BY b.awarded_at, b.user_id
+              LIMIT $2 OFFSET $3`
+
+	return m.queryBadges(ctx, query, badgeID, limit, offset)
+}
+
+func (m *DBModel) queryBadges(ctx context.Context, query string, args ...interface{}) ([]*UserBadge, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := []*UserBadge{}
+	for rows.Next() {
+		var b UserBadge
+		err := rows.Scan(&b.BadgeID, &b.UserID, &b.Username, &b.AwardedAt)
+		if err != nil {
+			return nil, err
+		}
+		badges = append(badges, &b)
+	}
+
+	return badges, rows.Err()
+}
+
+// BadgeCounts returns how many users hold each badge.
+func (m *DBModel) BadgeCounts() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT badge_id, count(*) FROM user_badges GROUP BY badge_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var n int
+		err := rows.Scan(&id, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// ContributorIDs returns the users who wrote at least one thread or reply,
+// the only ones who can have earned a badge.
+func (m *DBModel) ContributorIDs() ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `SELECT u.user_id FROM users u
+              WHERE EXISTS (SELECT 1 FROM threads t WHERE t.author_id = u.user_id)
+                 OR EXISTS (SELECT 1 FROM replies r WHERE r.author_id = u.user_id)
+              ORDER BY u.user_id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+func (m *DBModel) GetUserByIdentity(issuer, subject string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns("u") + `
+              FROM user_identities i
+              JOIN users u ON u.user_id = i.user_id
+              WHERE i.issuer = $1 AND i.subject = $2`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, issuer, subject))
+}
+
+// InsertUserWithIdentity creates a password-less user linked to an external
+// identity. When user.Username is taken a numeric suffix is appended; an
+// email address already used by another account is dropped rather than
+// linked, since the provider's word alone must not grant access to it.
+func (m *DBModel) InsertUserWithIdentity(user *User, issuer, subject string, emailVerified bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	base := user.Username
+	for i := 1; ; i++ {
+		var taken bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE lower(username) = lower($1))`, user.Username).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			break
+		}
+		if i == 100 {
+			return fmt.Errorf("no free username for %q", base)
+		}
+		user.Username = fmt.Sprintf("%s%d", base, i+1)
+	}
+
+	if user.Email != "" {
+		var taken bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1))`, user.Email).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			user.Email = ""
+		}
+	}
+
+	stmt := `INSERT INTO users (username, password, email, email_verified_at)
+             VALUES ($1, '', NULLIF($2, ''), CASE WHEN $3 AND $2 <> '' THEN now() END)
+             RETURNING user_id, role, email_verified_at, created_at`
+	err = tx.QueryRowContext(ctx, stmt, user.Username, user.Email, emailVerified).Scan(&user.UserID, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)`, issuer, subject, user.UserID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+func (m *DBModel) InsertInvitation(inv *Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO invitations (code_hash, note, max_uses, created_by, expires_at)
+             VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		inv.CodeHash,
+		inv.Note,
+		inv.MaxUses,
+		inv.CreatedBy,
+		inv.ExpiresAt,
+	).Scan(&inv.ID, &inv.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) Invitations() ([]*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, note, max_uses, uses, COALESCE(created_by, 0), created_at, expires_at, revoked_at
+              FROM invitations ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		err := rows.Scan(
+			&inv.ID,
+			&inv.Note,
+			&inv.MaxUses,
+			&inv.Uses,
+			&inv.CreatedBy,
+			&inv.CreatedAt,
+			&inv.ExpiresAt,
+			&inv.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &inv)
+	}
+
+	return invitations, rows.Err()
+}
+
+func (m *DBModel) RevokeInvitation(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE invitations SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// validInvitationClause matches invitations that can still be used.
+const validInvitationClause = `code_hash = $1 AND revoked_at IS NULL
+              AND (expires_at IS NULL OR expires_at > now()) AND uses < max_uses`
+
+// InvitationValid reports whether the code with hash can still be used,
+// without using it.
+func (m *DBModel) InvitationValid(hash []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var valid bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM invitations WHERE `+validInvitationClause+`)`, hash).Scan(&valid)
+	return valid, err
+}
+
+// RedeemInvitation uses up one use of the code with hash and returns the
+// invitation's ID, or sql.ErrNoRows when the code is unknown or used up.
+func (m *DBModel) RedeemInvitation(hash []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	stmt := `UPDATE invitations SET uses = uses + 1 WHERE ` + validInvitationClause + ` RETURNING id`
+	err := m.DB.QueryRowContext(ctx, stmt, hash).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ReleaseInvitation gives back a use whose signup failed after all.
+func (m *DBModel) ReleaseInvitation(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE invitations SET uses = uses - 1 WHERE id = $1 AND uses > 0`, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) SetUserInvitation(userID int, invitationID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET invitation_id = $1 WHERE user_id = $2`, invitationID, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
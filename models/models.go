@@ -22,6 +22,7 @@ type Thread struct {
 	AuthorID       int            `json:"author_id"`
 	AuthorName     string         `json:"author_name"`
 	Upvotes        int            `json:"upvotes"`
+	MyVote         int            `json:"my_vote"`
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	IsSolved       bool           `json:"is_solved"`
@@ -46,17 +47,380 @@ type ThreadCategory struct {
 }
 
 type User struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	UserID          int        `json:"user_id"`
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"display_name"`
+	Password        string     `json:"-"`
+	Role            string     `json:"role"`
+	Email           string     `json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	CreatedAt       time.Time  `json:"created_at"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason  string     `json:"disabled_reason,omitempty"`
+}
+
+// AdminUser is a row of the admin user list.
+type AdminUser struct {
+	UserID       int        `json:"user_id"`
+	Username     string     `json:"username"`
+	DisplayName  string     `json:"display_name"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastActiveAt *time.Time `json:"last_active_at"`
+	DisabledAt   *time.Time `json:"disabled_at"`
+	Threads      int        `json:"threads"`
+	Replies      int        `json:"replies"`
+}
+
+// UserSearch filters and orders the admin user list. Zero values do not
+// filter.
+type UserSearch struct {
+	Query         string
+	Role          string
+	JoinedAfter   time.Time
+	JoinedBefore  time.Time
+	ActiveSince   time.Time
+	InactiveSince time.Time
+	Disabled      *bool
+	Sort          string
+	Limit         int
+	Offset        int
+}
+
+// UserActivity summarizes what a user did, for admins.
+type UserActivity struct {
+	Stats             ProfileStats `json:"stats"`
+	LastActiveAt      *time.Time   `json:"last_active_at"`
+	LastSignInAt      *time.Time   `json:"last_sign_in_at"`
+	ActiveSessions    int          `json:"active_sessions"`
+	ActiveTokens      int          `json:"active_access_tokens"`
+	ThreadsLast30Days int          `json:"threads_last_30_days"`
+	RepliesLast30Days int          `json:"replies_last_30_days"`
+}
+
+// Profile is the public face of a user.
+type Profile struct {
+	UserID      int          `json:"user_id"`
+	Username    string       `json:"username"`
+	DisplayName string       `json:"display_name"`
+	Bio         string       `json:"bio"`
+	AvatarURL   string       `json:"avatar_url"`
+	Role        string       `json:"role"`
+	JoinedAt    time.Time    `json:"joined_at"`
+	Reputation  int          `json:"reputation"`
+	Stats       ProfileStats `json:"stats"`
+}
+
+type ProfileStats struct {
+	ThreadsAsked    int `json:"threads_asked"`
+	RepliesPosted   int `json:"replies_posted"`
+	AcceptedAnswers int `json:"accepted_answers"`
+	SolvedThreads   int `json:"solved_threads"`
+}
+
+// How a deleted account's threads and replies are treated.
+const (
+	DeletionAnonymize = "anonymize"
+	DeletionRemove    = "remove"
+)
+
+// DeletedAuthorName replaces the author of anonymized content.
+const DeletedAuthorName = "deleted user"
+
+// AccountDeletion is a pending request to delete an account.
+type AccountDeletion struct {
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"-"`
+	Mode        string    `json:"mode"`
+	RequestedAt time.Time `json:"requested_at"`
+	PurgeAfter  time.Time `json:"purge_after"`
+}
+
+// Orders a thread list can be sorted in.
+const (
+	ThreadSortNewest  = "newest"
+	ThreadSortOldest  = "oldest"
+	ThreadSortUpdated = "updated"
+	ThreadSortUpvotes = "upvotes"
+)
+
+var ThreadSorts = []string{ThreadSortNewest, ThreadSortOldest, ThreadSortUpdated, ThreadSortUpvotes}
+
+// ThreadQuery selects and orders the threads All returns.
+type ThreadQuery struct {
+	CategoryID int
+	Sort       string
+	HideSolved bool
+}
+
+// UserSettings are a user's preferences.
+type UserSettings struct {
+	TimeZone             string   `json:"time_zone"`
+	ThreadSort           string   `json:"thread_sort"`
+	NotificationChannels []string `json:"notification_channels"`
+	DigestFrequency      string   `json:"digest_frequency"`
+	ShowSolvedThreads    bool     `json:"show_solved_threads"`
+	Locale               string   `json:"locale"`
+}
+
+// DefaultUserSettings apply to anonymous visitors and to every setting a
+// user never changed.
+func DefaultUserSettings() UserSettings {
+	return UserSettings{
+		TimeZone:             "UTC",
+		ThreadSort:           ThreadSortNewest,
+		NotificationChannels: []string{"in_app"},
+		DigestFrequency:      "never",
+		ShowSolvedThreads:    true,
+		Locale:               "en",
+	}
+}
+
+// Scopes of the single-use tokens in user_tokens.
+const (
+	ScopePasswordReset     = "password-reset"
+	ScopeEmailVerification = "email-verification"
+	ScopeActivation        = "activation"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role ranks at or above required.
+func RoleAtLeast(role, required string) bool {
+	have, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+	return have >= roleRanks[required]
+}
+
+type Session struct {
+	ID               int64      `json:"id"`
+	UserID           int        `json:"user_id"`
+	RefreshTokenHash []byte     `json:"-"`
+	UserAgent        string     `json:"user_agent"`
+	IP               string     `json:"ip"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	Current          bool       `json:"current"`
+}
+
+// Scopes a personal access token can be granted. Access tokens from
+// signIn carry all of them.
+const (
+	AccessScopeRead         = "read"
+	AccessScopeWriteThreads = "write:threads"
+	AccessScopeWriteReplies = "write:replies"
+	AccessScopeAdmin        = "admin"
+)
+
+var AccessScopes = []string{AccessScopeRead, AccessScopeWriteThreads, AccessScopeWriteReplies, AccessScopeAdmin}
+
+func ValidAccessScope(scope string) bool {
+	for _, s := range AccessScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type PersonalAccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Invitation lets people sign up while registration is restricted. Code is
+// only set right after creation; the database keeps its hash.
+type Invitation struct {
+	ID        int64      `json:"id"`
+	Code      string     `json:"code,omitempty"`
+	CodeHash  []byte     `json:"-"`
+	Note      string     `json:"note"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type WebAuthnCredential struct {
+	ID         []byte     `json:"id"`
+	UserID     int        `json:"user_id"`
+	PublicKey  []byte     `json:"-"`
+	SignCount  uint32     `json:"-"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type AuthEvent struct {
+	ID        int64     `json:"id"`
+	Event     string    `json:"event"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Reply struct {
-	ID         int       `json:"id"`
-	ThreadID   int       `json:"thread_id"`
+	ID         int        `json:"id"`
+	ThreadID   int        `json:"thread_id"`
+	Content    string     `json:"content"`
+	AuthorID   int        `json:"author_id"`
+	AuthorName string     `json:"author_name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	IsAnswer   bool       `json:"is_answer"`
+	Score      int        `json:"score"`
+	MyVote     int        `json:"my_vote"`
+	ParentID   *int       `json:"parent_id"`
+	EditedAt   *time.Time `json:"edited_at"`
+	Deleted    bool       `json:"deleted"`
+	DeletedAt  *time.Time `json:"-"`
+
+	// Set when replies are returned as a tree: the first children, how
+	// many there are in total and, when some were left out, the cursor that
+	// loads the rest.
+	Children   []*Reply `json:"children,omitempty"`
+	ChildCount int      `json:"child_count"`
+	MoreCursor string   `json:"more_cursor,omitempty"`
+}
+
+// ReplyRevision is one version of a reply, the current one included. Diff
+// turns the previous version into this one.
+type ReplyRevision struct {
+	Revision   int       `json:"revision"`
 	Content    string    `json:"content"`
-	AuthorID   int       `json:"author_id"`
-	AuthorName string    `json:"author_name"`
+	EditorID   int       `json:"editor_id"`
+	EditorName string    `json:"editor_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Diff       string    `json:"diff,omitempty"`
+}
+
+// maskDeleted blanks out a tombstone, a deleted reply kept because others
+// replied to it.
+func (r *Reply) maskDeleted() {
+	if r.DeletedAt == nil {
+		return
+	}
+	r.Deleted = true
+	r.Content = ""
+	r.AuthorID = 0
+	r.AuthorName = ""
+	r.EditedAt = nil
+}
+
+const (
+	ReplySortOldest = "oldest"
+	ReplySortScore  = "score"
+)
+
+// ReplySorts lists the orders GetReplies knows. The accepted answer comes
+// first in all of them.
+var ReplySorts = []string{ReplySortOldest, ReplySortScore}
+
+// Reputation events. ReputationPoints says what each is worth to the user
+// credited.
+const (
+	ReputationReplyUpvoted   = "reply_upvoted"
+	ReputationAnswerAccepted = "answer_accepted"
+	ReputationThreadSolved   = "thread_solved"
+)
+
+var ReputationPoints = map[string]int{
+	ReputationReplyUpvoted:   10,
+	ReputationAnswerAccepted: 15,
+	ReputationThreadSolved:   2,
+}
+
+// Upvotes, accepted answers and solved threads together earn a user at
+// most ReputationDailyCap points per UTC day, and at most
+// ReputationDailyVoterCap of those from any one voter or asker.
+const (
+	ReputationDailyCap      = 200
+	ReputationDailyVoterCap = 30
+)
+
+type ReputationEvent struct {
+	ID         int64     `json:"id"`
+	UserID     int       `json:"user_id"`
+	Event      string    `json:"event"`
+	Points     int       `json:"points"`
+	SourceType string    `json:"source_type"`
+	SourceID   int       `json:"source_id"`
+	ActorID    *int      `json:"-"` // kept private so votes stay anonymous
+	Reverses   *int64    `json:"reverses,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
-	IsAnswer   bool      `json:"is_answer"`
+}
+
+// ReputationDrift is a user whose stored reputation disagrees with the
+// ledger.
+type ReputationDrift struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Stored   int    `json:"stored"`
+	Ledger   int    `json:"ledger"`
+}
+
+// BadgeStats is what badge rules are checked against.
+type BadgeStats struct {
+	ThreadsAsked     int
+	RepliesPosted    int
+	AcceptedAnswers  int
+	ThreadsAnswered  int // threads with an accepted answer by the user
+	SolvedThreads    int
+	CategoriesHelped int
+	UpvotesReceived  int
+	Reputation       int
+}
+
+// UserBadge is a badge awarded to a user.
+type UserBadge struct {
+	BadgeID   string    `json:"badge_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	AwardedAt time.Time `json:"awarded_at"`
+}
+
+// VoteTarget is what a vote is cast on.
+type VoteTarget string
+
+const (
+	VoteThread VoteTarget = "thread"
+	VoteReply  VoteTarget = "reply"
+)
+
+// Vote is one vote a user cast, on either a thread or a reply.
+type Vote struct {
+	ThreadID  *int      `json:"thread_id,omitempty"`
+	ReplyID   *int      `json:"reply_id,omitempty"`
+	Value     int       `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicate is returned when a write would break a unique constraint,
+// like taking a username someone else has.
+var ErrDuplicate = errors.New("models: duplicate value")
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (m *DBModel) GetProfile(userID int) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT u.user_id, u.username, u.display_name, u.bio, u.avatar_url, u.role, u.created_at, u.reputation,
+                     (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id),
+                     (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.deleted_at IS NULL),
+                     (SELECT count(*) FROM replies r WHERE r.author_id = u.user_id AND r.is_answer AND r.deleted_at IS NULL),
+                     (SELECT count(*) FROM threads t WHERE t.author_id = u.user_id AND t.is_solved)
+              FROM users u
+              WHERE u.user_id = $1`
+
+	var p Profile
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&p.UserID,
+		&p.Username,
+		&p.DisplayName,
+		&p.Bio,
+		&p.AvatarURL,
+		&p.Role,
+		&p.JoinedAt,
+		&p.Reputation,
+		&p.Stats.ThreadsAsked,
+		&p.Stats.RepliesPosted,
+		&p.Stats.AcceptedAnswers,
+		&p.Stats.SolvedThreads,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (m *DBModel) UpdateProfile(userID int, displayName, bio, avatarURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET display_name = $1, bio = $2, avatar_url = $3 WHERE user_id = $4`
+	_, err := m.DB.ExecContext(ctx, stmt, displayName, bio, avatarURL, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// SetUsername renames a user, returning ErrDuplicate when the name is taken
+// in any letter case.
+func (m *DBModel) SetUsername(userID int, username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET username = $1 WHERE user_id = $2`, username, userID)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// cappedReputation lists the events ReputationDailyCap applies to.
+var cappedReputation = []string{ReputationReplyUpvoted, ReputationAnswerAccepted, ReputationThreadSolved}
+
+// awardReputation credits userID with event in tx. Events over the daily
+// caps are still recorded, with fewer or no points; reversed events keep
+// counting, so marking and unmarking a solution cannot be repeated for
+// points. Nothing is credited to accounts that no longer exist.
+func awardReputation(ctx context.Context, tx *sql.Tx, userID int, event, sourceType string, sourceID, actorID int) error {
+	points := ReputationPoints[event]
+
+	// Locking the user serializes awards, so the caps hold under
+	// concurrent votes.
+	err := tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE`, userID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, capped := range cappedReputation {
+		if event != capped {
+			continue
+		}
+
+		var today, fromActor int
+		query := `SELECT COALESCE(sum(points), 0), COALESCE(sum(points) FILTER (WHERE actor_id = $2), 0)
+                  FROM reputation_events
+                  WHERE user_id = $1 AND reverses IS NULL AND event = ANY($3)
+                    AND created_at >= date_trunc('day', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`
+		err = tx.QueryRowContext(ctx, query, userID, actorID, pq.Array(cappedReputation)).Scan(&today, &fromActor)
+		if err != nil {
+			return err
+		}
+
+		if left := ReputationDailyCap - today; points > left {
+			points = left
+		}
+		if left := ReputationDailyVoterCap - fromActor; points > left {
+			points = left
+		}
+		if points < 0 {
+			points = 0
+		}
+	}
+
+	stmt := `INSERT INTO reputation_events (user_id, event, points, source_type, source_id, actor_id)
+             VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt, userID, event, points, sourceType, sourceID, actorID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET reputation = reputation + $1 WHERE user_id = $2`, points, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// reverseReputation undoes, in tx, every event matching condition that is
+// not undone yet. condition is an SQL expression over the events aliased e.
+func reverseReputation(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) error {
+	stmt := `WITH reversed AS (
+                 INSERT INTO reputation_events (user_id, event, points, source_type, source_id, actor_id, reverses)
+                 SELECT e.user_id, e.event, -e.points, e.source_type, e.source_id, e.actor_id, e.id
+                 FROM reputation_events e
+                 WHERE (` + condition + `)
+                   AND e.reverses IS NULL
+                   AND NOT EXISTS (SELECT 1 FROM reputation_events r WHERE r.reverses = e.id)
+                 RETURNING user_id, points
+             )
+             UPDATE users u SET reputation = u.reputation + s.points
+             FROM (SELECT user_id, sum(points) AS points FROM reversed GROUP BY user_id) s
+             WHERE u.user_id = s.user_id`
+	_, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// ReputationHistory returns the user's most recent ledger entries, all of
+// them when limit is 0.
+func (m *DBModel) ReputationHistory(userID, limit int) ([]*ReputationEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, event, points, source_type, source_id, actor_id, reverses, created_at
+              FROM reputation_events
+              WHERE user_id = $1
+              ORDER BY id DESC
+              LIMIT NULLIF($2, 0)`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*ReputationEvent{}
+	for rows.Next() {
+		var e ReputationEvent
+		err := rows.Scan(&e.ID, &e.UserID, &e.Event, &e.Points, &e.SourceType, &e.SourceID, &e.ActorID, &e.Reverses, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
+
+// ReconcileReputation compares every user's stored reputation with the sum
+// of their ledger and returns those that differ. With fix set the stored
+// totals are overwritten with the ledger's.
+func (m *DBModel) ReconcileReputation(fix bool) ([]*ReputationDrift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Block awards while comparing so a concurrent vote is not mistaken for
+	// drift.
+	_, err = tx.ExecContext(ctx, `LOCK TABLE reputation_events IN SHARE MODE`)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT u.user_id, u.username, u.reputation, COALESCE(s.total, 0)
+              FROM users u
+              LEFT JOIN (SELECT user_id, sum(points) AS total FROM reputation_events GROUP BY user_id) s
+                     ON s.user_id = u.user_id
+              WHERE u.reputation <> COALESCE(s.total, 0)
+              ORDER BY u.user_id`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []*ReputationDrift{}
+	for rows.Next() {
+		var d ReputationDrift
+		err := rows.Scan(&d.UserID, &d.Username, &d.Stored, &d.Ledger)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		drifts = append(drifts, &d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !fix {
+		return drifts, nil
+	}
+
+	for _, d := range drifts {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET reputation = $1 WHERE user_id = $2`, d.Ledger, d.UserID)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	return drifts, tx.Commit()
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrReplyDeleted is returned when editing a reply that was deleted.
+var ErrReplyDeleted = errors.New("reply was deleted")
+
+// UpdateReply replaces the content of a reply, keeping the version it
+// replaces as a revision. Saving unchanged content is a no-op.
+func (m *DBModel) UpdateReply(id int, content string, editorID int) (*Reply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var authorID int
+	var editedBy sql.NullInt64
+	var versionAt time.Time
+	var deleted bool
+	query := `SELECT content, author_id, edited_by, COALESCE(edited_at, created_at), deleted_at IS NOT NULL
+              FROM replies WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&current, &authorID, &editedBy, &versionAt, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrReplyDeleted
+	}
+
+	if content != current {
+		writer := authorID
+		if editedBy.Valid {
+			writer = int(editedBy.Int64)
+		}
+
+		stmt := `INSERT INTO reply_revisions (reply_id, revision, content, editor_id, created_at)
+                 SELECT $1, COALESCE(max(revision), 0) + 1, $2, $3, $4 FROM reply_revisions WHERE reply_id = $1`
+		_, err = tx.ExecContext(ctx, stmt, id, current, writer, versionAt)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+
+		stmt = `UPDATE replies SET content = $1, edited_at = now(), edited_by = $2 WHERE id = $3`
+		_, err = tx.ExecContext(ctx, stmt, content, editorID, id)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetReply(id)
+}
+
+// ReplyRevisions returns every version of a reply, oldest first, ending
+// with the current one.
+func (m *DBModel) ReplyRevisions(id int) ([]*ReplyRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT v.revision, v.content, COALESCE(v.editor_id, 0), COALESCE(u.username, ''), v.created_at
+              FROM reply_revisions v
+              LEFT JOIN users u ON u.user_id = v.editor_id
+              WHERE v.reply_id = $1
+              UNION ALL
+              SELECT (SELECT count(*) + 1 FROM reply_revisions WHERE reply_id = r.id), r.content,
+                     COALESCE(r.edited_by, r.author_id), COALESCE(u.username, CASE WHEN r.edited_by IS NULL THEN r.author_name ELSE '' END),
+                     COALESCE(r.edited_at, r.created_at)
+              FROM replies r
+              LEFT JOIN users u ON u.user_id = COALESCE(r.edited_by, r.author_id)
+              WHERE r.id = $1 AND r.deleted_at IS NULL
+              ORDER BY 1`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*ReplyRevision
+	for rows.Next() {
+		var v ReplyRevision
+		err := rows.Scan(&v.Revision, &v.Content, &v.EditorID, &v.EditorName, &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return revisions, nil
+}
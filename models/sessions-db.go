@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+func (m *DBModel) InsertSession(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip, expires_at)
+             VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_used_at`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		session.UserID,
+		session.RefreshTokenHash,
+		session.UserAgent,
+		session.IP,
+		session.ExpiresAt,
+	).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) GetSession(id int64) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, refresh_token_hash, user_agent, ip, created_at, last_used_at, expires_at, revoked_at
+              FROM sessions WHERE id = $1`
+
+	var session Session
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.RefreshTokenHash,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RotateSession swaps the refresh token of a live session. It returns
+// sql.ErrNoRows when oldHash is not the session's current token, so a refresh
+// token can only ever be used once.
+func (m *DBModel) RotateSession(id int64, oldHash, newHash []byte, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET refresh_token_hash = $1, expires_at = $2, last_used_at = now()
+             WHERE id = $3 AND refresh_token_hash = $4 AND revoked_at IS NULL AND expires_at > now()`
+	result, err := m.DB.ExecContext(ctx, stmt, newHash, expiresAt, id, oldHash)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SessionActive is the revocation check consulted for every access token.
+func (m *DBModel) SessionActive(id int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+	query := `SELECT EXISTS(SELECT 1 FROM sessions s JOIN users u ON u.user_id = s.user_id
+                            WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > now() AND u.disabled_at IS NULL)`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
+func (m *DBModel) SessionsForUser(userID int) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_used_at, expires_at
+              FROM sessions
+              WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+              ORDER BY last_used_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of userID's sessions, returning sql.ErrNoRows
+// when no such live session belongs to the user.
+func (m *DBModel) RevokeSession(id int64, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *DBModel) RevokeUserSessions(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
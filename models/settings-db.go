@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// GetUserSettings returns the user's settings, with defaults for everything
+// they never set.
+func (m *DBModel) GetUserSettings(userID int) (*UserSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	settings := DefaultUserSettings()
+
+	var stored []byte
+	err := m.DB.QueryRowContext(ctx, `SELECT settings FROM user_settings WHERE user_id = $1`, userID).Scan(&stored)
+	if err == nil {
+		err = json.Unmarshal(stored, &settings)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (m *DBModel) SetUserSettings(userID int, settings *UserSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	js, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO user_settings (user_id, settings, updated_at) VALUES ($1, $2, now())
+             ON CONFLICT (user_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = now()`
+	_, err = m.DB.ExecContext(ctx, stmt, userID, js)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
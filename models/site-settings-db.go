@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Site-wide settings changed by admins at runtime.
+const (
+	SiteSettingRequire2FARoles = "require_2fa_roles"
+	SiteSettingRegistration    = "registration"
+)
+
+// GetSiteSetting decodes the JSON value stored under key into dest. It
+// returns false, leaving dest untouched, when the setting was never set.
+func (m *DBModel) GetSiteSetting(key string, dest interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value []byte
+	err := m.DB.QueryRowContext(ctx, `SELECT value FROM site_settings WHERE key = $1`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(value, dest)
+}
+
+func (m *DBModel) SetSiteSetting(key string, value interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO site_settings (key, value, updated_at) VALUES ($1, $2, now())
+             ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`
+	_, err = m.DB.ExecContext(ctx, stmt, key, js)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -17,8 +18,10 @@ func (m *DBModel) Get(id int) (*Thread, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT id, title, content, author_id, author_name, upvotes, created_at, updated_at, is_solved
-		      FROM threads WHERE id = $1`
+	query := `SELECT t.id, t.title, t.content, t.author_id, COALESCE(u.username, t.author_name), t.upvotes, t.created_at, t.updated_at, t.is_solved
+		      FROM threads t
+		      LEFT JOIN users u ON u.user_id = t.author_id
+		      WHERE t.id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 
@@ -73,19 +76,42 @@ func (m *DBModel) Get(id int) (*Thread, error) {
 	return &thread, nil
 }
 
-func (m *DBModel) All(category ...int) ([]*Thread, error) {
+// threadOrder maps the ThreadSort* values to ORDER BY clauses.
+var threadOrder = map[string]string{
+	ThreadSortNewest:  "t.id desc",
+	ThreadSortOldest:  "t.id asc",
+	ThreadSortUpdated: "t.updated_at desc, t.id desc",
+	ThreadSortUpvotes: "t.upvotes desc, t.id desc",
+}
+
+func (m *DBModel) All(q ThreadQuery) ([]*Thread, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	var conditions []string
+	if q.CategoryID != 0 {
+		conditions = append(conditions, fmt.Sprintf("t.id in (select thread_id from threads_categories where category_id = %d)", q.CategoryID))
+	}
+	if q.HideSolved {
+		conditions = append(conditions, "NOT t.is_solved")
+	}
+
 	where := ""
-	if len(category) > 0 {
-		where = fmt.Sprintf("where id in (select thread_id from threads_categories where category_id = %d)", category[0])
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
+	}
+
+	order, ok := threadOrder[q.Sort]
+	if !ok {
+		order = threadOrder[ThreadSortNewest]
 	}
 
 	query := fmt.Sprintf(
-		`SELECT id, title, content, author_id, author_name, upvotes, created_at, updated_at, is_solved
-			FROM threads %s order by id desc`,
-		where)
+		`SELECT t.id, t.title, t.content, t.author_id, COALESCE(u.username, t.author_name), t.upvotes, t.created_at, t.updated_at, t.is_solved
+			FROM threads t
+			LEFT JOIN users u ON u.user_id = t.author_id
+			%s order by %s`,
+		where, order)
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -153,10 +179,11 @@ func (m *DBModel) YourThreads(authorID int) ([]*Thread, error) {
     ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
     defer cancel()
 
-    query := `SELECT id, title, content, author_id, created_at, updated_at, is_solved
-              FROM threads
-              WHERE author_id = $1
-              ORDER BY created_at DESC`
+    query := `SELECT t.id, t.title, t.content, t.author_id, COALESCE(u.username, t.author_name), t.created_at, t.updated_at, t.is_solved
+              FROM threads t
+              LEFT JOIN users u ON u.user_id = t.author_id
+              WHERE t.author_id = $1
+              ORDER BY t.created_at DESC`
 
     rows, err := m.DB.QueryContext(ctx, query, authorID)
     if err != nil {
@@ -172,6 +199,7 @@ func (m *DBModel) YourThreads(authorID int) ([]*Thread, error) {
             &thread.Title,
             &thread.Content,
             &thread.AuthorID,
+            &thread.AuthorName,
             &thread.CreatedAt,
             &thread.UpdatedAt,
             &thread.IsSolved,
@@ -316,12 +344,26 @@ func (m *DBModel) UpdateThread(thread Thread) error {
 	return nil
 }
 
+// DeleteThread removes a thread and takes back the reputation it and its
+// replies earned.
 func (m *DBModel) DeleteThread(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = reverseReputation(ctx, tx, `(e.source_type = 'thread' AND e.source_id = $1)
+                                      OR (e.source_type = 'reply' AND e.source_id IN (SELECT id FROM replies WHERE thread_id = $1))`, id)
+	if err != nil {
+		return err
+	}
+
 	stmtCategory := `delete from threads_categories where thread_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmtCategory, id)
+	_, err = tx.ExecContext(ctx, stmtCategory, id)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -329,23 +371,31 @@ func (m *DBModel) DeleteThread(id int) error {
 
 	stmt := `delete from threads where id = $1`
 
-	_, err = m.DB.ExecContext(ctx, stmt, id)
+	_, err = tx.ExecContext(ctx, stmt, id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (m *DBModel) ToggleSolved(id int) (*Thread, error) {
+// ToggleSolved flips whether a thread is solved, crediting its author for
+// solving it or taking the credit back. actorID is who toggled it.
+func (m *DBModel) ToggleSolved(id, actorID int) (*Thread, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `UPDATE threads SET is_solved = NOT is_solved WHERE id = $1 RETURNING id, title, content, author_id, author_name, upvotes, created_at, updated_at, is_solved`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := tx.QueryRowContext(ctx, query, id)
 
 	var thread Thread
-	err := row.Scan(
+	err = row.Scan(
 		&thread.ID,
 		&thread.Title,
 		&thread.Content,
@@ -361,46 +411,92 @@ func (m *DBModel) ToggleSolved(id int) (*Thread, error) {
 		return nil, err
 	}
 
-	return &thread, nil
+	if thread.IsSolved {
+		err = awardReputation(ctx, tx, thread.AuthorID, ReputationThreadSolved, "thread", thread.ID, actorID)
+	} else {
+		err = reverseReputation(ctx, tx, `e.event = $1 AND e.source_type = 'thread' AND e.source_id = $2`, ReputationThreadSolved, thread.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &thread, tx.Commit()
 }
 
 func (m *DBModel) GetUserByUsername(username string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT user_id, username, password FROM users WHERE username = $1`
+	query := `SELECT ` + userColumns("u") + ` FROM users u WHERE lower(u.username) = lower($1)`
 	row := m.DB.QueryRowContext(ctx, query, username)
 
-	var user User
-	err := row.Scan(&user.UserID, &user.Username, &user.Password)
+	return scanUser(row)
+}
+
+func (m *DBModel) GetUserByID(id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns("u") + ` FROM users u WHERE u.user_id = $1`
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	return scanUser(row)
+}
+
+func (m *DBModel) InsertUser(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO users (username, password, email) VALUES ($1, $2, NULLIF($3, '')) RETURNING user_id, role, created_at`
+	err := m.DB.QueryRowContext(ctx, query, user.Username, user.Password, user.Email).Scan(&user.UserID, &user.Role, &user.CreatedAt)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &user, nil
+	return nil
 }
 
-func (m *DBModel) InsertUser(user *User) error {
+func (m *DBModel) SetUserRole(id int, role string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO users (username, password) VALUES ($1, $2) RETURNING user_id`
-	err := m.DB.QueryRowContext(ctx, query, user.Username, user.Password).Scan(&user.UserID)
+	result, err := m.DB.ExecContext(ctx, `UPDATE users SET role = $1 WHERE user_id = $2`, role, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
 
 	return nil
 }
 
-func (m *DBModel) GetReplies(threadID int) ([]*Reply, error) {
+// replyOrder maps the ReplySort* values to ORDER BY clauses.
+var replyOrder = map[string]string{
+	ReplySortOldest: "r.is_answer DESC, r.created_at ASC",
+	ReplySortScore:  "r.is_answer DESC, r.score DESC, r.created_at ASC",
+}
+
+func (m *DBModel) GetReplies(threadID int, sort string) ([]*Reply, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT id, thread_id, content, author_id, author_name, created_at, is_answer
-              FROM replies
-              WHERE thread_id = $1
-              ORDER BY is_answer DESC, created_at ASC`
+	order, ok := replyOrder[sort]
+	if !ok {
+		order = replyOrder[ReplySortOldest]
+	}
+
+	query := `SELECT r.id, r.thread_id, r.parent_id, r.content, r.author_id, COALESCE(u.username, r.author_name), r.created_at, r.is_answer, r.score, r.deleted_at, r.edited_at
+              FROM replies r
+              LEFT JOIN users u ON u.user_id = r.author_id
+              WHERE r.thread_id = $1
+              ORDER BY ` + order
 
 	rows, err := m.DB.QueryContext(ctx, query, threadID)
 
@@ -412,23 +508,60 @@ func (m *DBModel) GetReplies(threadID int) ([]*Reply, error) {
 	var replies []*Reply
 	for rows.Next() {
 		var reply Reply
-		err := rows.Scan(&reply.ID, &reply.ThreadID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer)
+		err := rows.Scan(&reply.ID, &reply.ThreadID, &reply.ParentID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer, &reply.Score, &reply.DeletedAt, &reply.EditedAt)
 		if err != nil {
 			return nil, err
 		}
+		reply.maskDeleted()
 		replies = append(replies, &reply)
 
 	}
 	return replies, nil
 }
 
+func (m *DBModel) GetReply(id int) (*Reply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT r.id, r.thread_id, r.parent_id, r.content, r.author_id, COALESCE(u.username, r.author_name), r.created_at, r.is_answer, r.score, r.deleted_at, r.edited_at
+              FROM replies r
+              LEFT JOIN users u ON u.user_id = r.author_id
+              WHERE r.id = $1`
+
+	var reply Reply
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&reply.ID, &reply.ThreadID, &reply.ParentID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer, &reply.Score, &reply.DeletedAt, &reply.EditedAt)
+	if err != nil {
+		return nil, err
+	}
+	reply.maskDeleted()
+
+	return &reply, nil
+}
+
+// ErrInvalidParent is returned for replies to a reply that is deleted or
+// belongs to another thread.
+var ErrInvalidParent = errors.New("parent must be a reply in the same thread that is not deleted")
+
 func (m *DBModel) InsertReply(reply Reply) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `INSERT INTO replies (thread_id, content, author_id, author_name, created_at, is_answer) VALUES ($1, $2, $3, $4, $5, $6)`
+	if reply.ParentID != nil {
+		var valid bool
+		query := `SELECT EXISTS(SELECT 1 FROM replies WHERE id = $1 AND thread_id = $2 AND deleted_at IS NULL)`
+		err := m.DB.QueryRowContext(ctx, query, *reply.ParentID, reply.ThreadID).Scan(&valid)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return ErrInvalidParent
+		}
+	}
+
+	stmt := `INSERT INTO replies (thread_id, parent_id, content, author_id, author_name, created_at, is_answer) VALUES ($1, $2, $3, $4, $5, $6, $7)`
 	_, err := m.DB.ExecContext(ctx, stmt,
 		reply.ThreadID,
+		reply.ParentID,
 		reply.Content,
 		reply.AuthorID,
 		reply.AuthorName,
@@ -444,34 +577,115 @@ func (m *DBModel) InsertReply(reply Reply) error {
 
 }
 
+// DeleteReply removes a reply and takes back the reputation it earned. A
+// reply others replied to becomes a tombstone instead, and tombstones left
+// without children by the deletion are removed as well.
 func (m *DBModel) DeleteReply(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `delete from replies where id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	err = reverseReputation(ctx, tx, `e.source_type = 'reply' AND e.source_id = $1`, id)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
-	return nil
+
+	for {
+		var hasChildren bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM replies WHERE parent_id = $1)`, id).Scan(&hasChildren)
+		if err != nil {
+			return err
+		}
+
+		if hasChildren {
+			stmt := `UPDATE replies SET content = '', is_answer = false, score = 0, deleted_at = COALESCE(deleted_at, now()) WHERE id = $1`
+			_, err = tx.ExecContext(ctx, stmt, id)
+			if err == nil {
+				_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE reply_id = $1`, id)
+			}
+			if err == nil {
+				_, err = tx.ExecContext(ctx, `DELETE FROM reply_revisions WHERE reply_id = $1`, id)
+			}
+			if err != nil {
+				log.Println(err)
+				return err
+			}
+			break
+		}
+
+		stmt := `delete from replies where id = $1 returning parent_id`
+
+		var parentID sql.NullInt64
+		err = tx.QueryRowContext(ctx, stmt, id).Scan(&parentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if !parentID.Valid {
+			break
+		}
+
+		var tombstone bool
+		err = tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM replies WHERE id = $1`, parentID.Int64).Scan(&tombstone)
+		if err != nil {
+			return err
+		}
+		if !tombstone {
+			break
+		}
+		id = int(parentID.Int64)
+	}
+
+	return tx.Commit()
 }
 
-func (m *DBModel) ToggleAnswer(id int) (*Reply, error) {
+// ToggleAnswer flips whether a reply answers its thread, crediting the
+// reply's author or taking the credit back. Answering your own thread earns
+// nothing. actorID is who toggled it.
+func (m *DBModel) ToggleAnswer(id, actorID int) (*Reply, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `UPDATE replies SET is_answer = NOT is_answer WHERE id = $1 RETURNING id, thread_id, content, author_id, author_name, created_at, is_answer`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE replies SET is_answer = NOT is_answer WHERE id = $1 AND deleted_at IS NULL
+              RETURNING id, thread_id, parent_id, content, author_id, author_name, created_at, is_answer, score`
+	row := tx.QueryRowContext(ctx, query, id)
 
 	var reply Reply
-	err := row.Scan(&reply.ID, &reply.ThreadID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer)
+	err = row.Scan(&reply.ID, &reply.ThreadID, &reply.ParentID, &reply.Content, &reply.AuthorID, &reply.AuthorName, &reply.CreatedAt, &reply.IsAnswer, &reply.Score)
 	if err != nil {
 		return nil, err
 	}
 
-	return &reply, nil
+	var asker int
+	err = tx.QueryRowContext(ctx, `SELECT author_id FROM threads WHERE id = $1`, reply.ThreadID).Scan(&asker)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.IsAnswer && reply.AuthorID != asker {
+		err = awardReputation(ctx, tx, reply.AuthorID, ReputationAnswerAccepted, "reply", reply.ID, actorID)
+	} else if !reply.IsAnswer {
+		err = reverseReputation(ctx, tx, `e.event = $1 AND e.source_type = 'reply' AND e.source_id = $2`, ReputationAnswerAccepted, reply.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, tx.Commit()
 }
 
 
@@ -502,9 +716,10 @@ func (m *DBModel) GetStarredThreads(userID int) ([]*Thread, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-    query := `SELECT t.id, t.title, t.content, t.author_id, t.created_at, t.updated_at, t.is_solved
+    query := `SELECT t.id, t.title, t.content, t.author_id, COALESCE(u.username, t.author_name), t.created_at, t.updated_at, t.is_solved
               FROM threads t
               JOIN starred_threads s ON t.id = s.thread_id
+              LEFT JOIN users u ON u.user_id = t.author_id
               WHERE s.user_id = $1
               ORDER BY s.created_at DESC`
 
@@ -522,6 +737,7 @@ func (m *DBModel) GetStarredThreads(userID int) ([]*Thread, error) {
 			&thread.Title,
 			&thread.Content,
 			&thread.AuthorID,
+			&thread.AuthorName,
 			&thread.CreatedAt,
 			&thread.UpdatedAt,
 			&thread.IsSolved,
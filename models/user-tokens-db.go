@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (m *DBModel) GetUserByEmail(email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns("u") + ` FROM users u WHERE lower(u.email) = lower($1)`
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	return scanUser(row)
+}
+
+func (m *DBModel) InsertUserToken(hash []byte, userID int, scope string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO user_tokens (hash, user_id, scope, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := m.DB.ExecContext(ctx, stmt, hash, userID, scope, expiresAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// GetUserForToken returns the owner of an unused, unexpired token without
+// consuming it.
+func (m *DBModel) GetUserForToken(hash []byte, scope string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns("u") + `
+              FROM user_tokens t
+              JOIN users u ON u.user_id = t.user_id
+              WHERE t.hash = $1 AND t.scope = $2 AND t.used_at IS NULL AND t.expires_at > now()`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, hash, scope))
+}
+
+// ConsumeUserToken marks a token used and returns its owner. A token can be
+// consumed once; afterwards, or once expired, sql.ErrNoRows is returned.
+func (m *DBModel) ConsumeUserToken(hash []byte, scope string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE user_tokens SET used_at = now()
+             WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expires_at > now()
+             RETURNING user_id`
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, stmt, hash, scope).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteUserTokens drops every outstanding token of scope for the user.
+func (m *DBModel) DeleteUserTokens(userID int, scope string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM user_tokens WHERE user_id = $1 AND scope = $2`, userID, scope)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) SetUserPassword(userID int, hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET password = $1 WHERE user_id = $2`, hash, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *DBModel) MarkEmailVerified(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET email_verified_at = now() WHERE user_id = $1 AND email_verified_at IS NULL`
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// InsertRosterUser creates an account without any way to sign in yet; the
+// person activates it through an activation token.
+func (m *DBModel) InsertRosterUser(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO users (username, password, email, role, display_name, rostered)
+             VALUES ($1, '', $2, $3, $4, true) RETURNING user_id, created_at`
+	err := m.DB.QueryRowContext(ctx, stmt, user.Username, user.Email, user.Role, user.DisplayName).Scan(&user.UserID, &user.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// IsRosterUser reports whether a roster import created the account of
+// userID.
+func (m *DBModel) IsRosterUser(userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rostered bool
+	err := m.DB.QueryRowContext(ctx, `SELECT rostered FROM users WHERE user_id = $1`, userID).Scan(&rostered)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return rostered, nil
+}
+
+func (m *DBModel) SetDisplayName(userID int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET display_name = $1 WHERE user_id = $2`, name, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
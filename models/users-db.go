@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// userColumns lists the users columns read by scanUser, qualified with the
+// table alias used by the query.
+func userColumns(alias string) string {
+	return fmt.Sprintf(`%[1]s.user_id, %[1]s.username, %[1]s.password, %[1]s.role,
+		COALESCE(%[1]s.email, ''), %[1]s.email_verified_at, %[1]s.totp_enabled_at IS NOT NULL,
+		%[1]s.created_at, %[1]s.display_name, %[1]s.disabled_at, %[1]s.disabled_reason`, alias)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.UserID,
+		&user.Username,
+		&user.Password,
+		&user.Role,
+		&user.Email,
+		&user.EmailVerifiedAt,
+		&user.TOTPEnabled,
+		&user.CreatedAt,
+		&user.DisplayName,
+		&user.DisabledAt,
+		&user.DisabledReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetTOTPSecret returns the user's TOTP secret, pending or enabled, and the
+// last time step a code was accepted for.
+func (m *DBModel) GetTOTPSecret(userID int) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var secret sql.NullString
+	var lastStep int64
+	query := `SELECT totp_secret, totp_last_step FROM users WHERE user_id = $1`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return "", 0, err
+	}
+	if !secret.Valid {
+		return "", 0, sql.ErrNoRows
+	}
+
+	return secret.String, lastStep, nil
+}
+
+// SetPendingTOTPSecret stores a secret that only takes effect once EnableTOTP
+// confirms the user's authenticator produces matching codes.
+func (m *DBModel) SetPendingTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE user_id = $2 AND totp_enabled_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, secret, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on two-factor sign-in and replaces the recovery codes.
+func (m *DBModel) EnableTOTP(userID int, recoveryCodeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET totp_enabled_at = now() WHERE user_id = $1 AND totp_secret IS NOT NULL`, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, hashes [][]byte) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *DBModel) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE user_id = $1`
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false
+// when that step or a later one was used before, so codes cannot be replayed.
+func (m *DBModel) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_last_step = $1 WHERE user_id = $2 AND totp_last_step < $1`
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// UseRecoveryCode consumes a recovery code, returning false when it does not
+// exist or was used already.
+func (m *DBModel) UseRecoveryCode(userID int, hash []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := m.DB.ExecContext(ctx, stmt, userID, hash)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSelfVote is returned when users vote on their own thread or reply.
+var ErrSelfVote = errors.New("cannot vote on your own post")
+
+// voteTargets maps a VoteTarget to its table, the table's score column, the
+// matching column of votes and the condition rows must meet to be voted on.
+var voteTargets = map[VoteTarget]struct{ table, score, column, live string }{
+	VoteThread: {"threads", "upvotes", "thread_id", "true"},
+	VoteReply:  {"replies", "score", "reply_id", "deleted_at IS NULL"},
+}
+
+// SetVote records the user's vote on a thread or reply and returns the new
+// score. A value of 0 withdraws the vote; setting the same vote twice
+// changes nothing. The target row stays locked until the score is updated,
+// so concurrent votes cannot lose each other's change.
+func (m *DBModel) SetVote(userID int, target VoteTarget, id int, value int) (int, error) {
+	t, ok := voteTargets[target]
+	if !ok {
+		return 0, errors.New("unknown vote target")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	err = tx.QueryRowContext(ctx, `SELECT author_id FROM `+t.table+` WHERE id = $1 AND `+t.live+` FOR UPDATE`, id).Scan(&authorID)
+	if err != nil {
+		return 0, err
+	}
+	if authorID == userID {
+		return 0, ErrSelfVote
+	}
+
+	var previous int
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE((SELECT value FROM votes WHERE user_id = $1 AND `+t.column+` = $2), 0)`, userID, id).Scan(&previous)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE user_id = $1 AND `+t.column+` = $2`, userID, id)
+	} else if value != previous {
+		_, err = tx.ExecContext(ctx, `INSERT INTO votes (user_id, `+t.column+`, value) VALUES ($1, $2, $3)
+                                      ON CONFLICT (user_id, `+t.column+`) WHERE `+t.column+` IS NOT NULL
+                                      DO UPDATE SET value = EXCLUDED.value, updated_at = now()`, userID, id, value)
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	if target == VoteReply && value != previous {
+		if previous == 1 {
+			err = reverseReputation(ctx, tx, `e.event = $1 AND e.source_type = $2 AND e.source_id = $3 AND e.actor_id = $4`,
+				ReputationReplyUpvoted, string(VoteReply), id, userID)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if value == 1 {
+			err = awardReputation(ctx, tx, authorID, ReputationReplyUpvoted, string(VoteReply), id, userID)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var score int
+	err = tx.QueryRowContext(ctx, `UPDATE `+t.table+` SET `+t.score+` = `+t.score+` + $1 WHERE id = $2 RETURNING `+t.score, value-previous, id).Scan(&score)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	return score, tx.Commit()
+}
+
+// UserVotes returns the user's votes on the given threads or replies, keyed
+// by their ID. Targets without a vote are missing from the map.
+func (m *DBModel) UserVotes(userID int, target VoteTarget, ids []int) (map[int]int, error) {
+	t, ok := voteTargets[target]
+	if !ok {
+		return nil, errors.New("unknown vote target")
+	}
+
+	votes := make(map[int]int)
+	if userID == 0 || len(ids) == 0 {
+		return votes, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + t.column + `, value FROM votes WHERE user_id = $1 AND ` + t.column + ` = ANY($2)`
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, value int
+		err := rows.Scan(&id, &value)
+		if err != nil {
+			return nil, err
+		}
+		votes[id] = value
+	}
+
+	return votes, rows.Err()
+}
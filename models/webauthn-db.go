@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// EnsureWebAuthnHandle returns the user's WebAuthn user handle, storing
+// handle as it when the user has none yet.
+func (m *DBModel) EnsureWebAuthnHandle(userID int, handle []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users SET webauthn_handle = COALESCE(webauthn_handle, $1) WHERE user_id = $2 RETURNING webauthn_handle`
+	var stored []byte
+	err := m.DB.QueryRowContext(ctx, stmt, handle, userID).Scan(&stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (m *DBModel) InsertWebAuthnCredential(cred *WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, name)
+             VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	err := m.DB.QueryRowContext(ctx, stmt, cred.ID, cred.UserID, cred.PublicKey, int64(cred.SignCount), cred.Name).Scan(&cred.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// InsertUserWithCredential creates a passkey-only account: no password, the
+// given user handle and its first credential.
+func (m *DBModel) InsertUserWithCredential(user *User, handle []byte, cred *WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO users (username, password, email, webauthn_handle) VALUES ($1, '', NULLIF($2, ''), $3) RETURNING user_id, role, created_at`
+	err = tx.QueryRowContext(ctx, stmt, user.Username, user.Email, handle).Scan(&user.UserID, &user.Role, &user.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	cred.UserID = user.UserID
+	stmt = `INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, name)
+            VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	err = tx.QueryRowContext(ctx, stmt, cred.ID, cred.UserID, cred.PublicKey, int64(cred.SignCount), cred.Name).Scan(&cred.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWebAuthnCredential returns a credential and its owner's user handle.
+func (m *DBModel) GetWebAuthnCredential(id []byte) (*WebAuthnCredential, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT c.id, c.user_id, c.public_key, c.sign_count, c.name, c.created_at, c.last_used_at, u.webauthn_handle
+              FROM webauthn_credentials c
+              JOIN users u ON u.user_id = c.user_id
+              WHERE c.id = $1`
+
+	var cred WebAuthnCredential
+	var signCount int64
+	var handle []byte
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&cred.ID,
+		&cred.UserID,
+		&cred.PublicKey,
+		&signCount,
+		&cred.Name,
+		&cred.CreatedAt,
+		&cred.LastUsedAt,
+		&handle,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	cred.SignCount = uint32(signCount)
+
+	return &cred, handle, nil
+}
+
+func (m *DBModel) WebAuthnCredentialsForUser(userID int) ([]*WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, created_at, last_used_at
+              FROM webauthn_credentials
+              WHERE user_id = $1
+              ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*WebAuthnCredential
+	for rows.Next() {
+		var cred WebAuthnCredential
+		err := rows.Scan(&cred.ID, &cred.UserID, &cred.Name, &cred.CreatedAt, &cred.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, &cred)
+	}
+
+	return creds, nil
+}
+
+func (m *DBModel) UpdateWebAuthnSignCount(id []byte, signCount uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE webauthn_credentials SET sign_count = $1, last_used_at = now() WHERE id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, int64(signCount), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's credentials unless it
+// is the only way left to sign in to a passkey-only account. It reports
+// whether a credential was deleted.
+func (m *DBModel) DeleteWebAuthnCredential(id []byte, userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM webauthn_credentials c
+             WHERE c.id = $1 AND c.user_id = $2
+               AND (EXISTS (SELECT 1 FROM users u WHERE u.user_id = c.user_id AND u.password <> '')
+                    OR (SELECT count(*) FROM webauthn_credentials o WHERE o.user_id = c.user_id) > 1)`
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
@@ -0,0 +1,346 @@
+// Package roster imports class rosters: CSV files with a name, email and
+// optional role column per student. Importing is idempotent by email, so a
+// roster can be imported again after changes; students who have not yet
+// activated their account get a fresh activation link each time. Existing
+// accounts are only matched by a verified email.
+package roster
+
+import (
+	"backend/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result statuses.
+const (
+	StatusCreated   = "created"
+	StatusUpdated   = "updated"
+	StatusUnchanged = "unchanged"
+	StatusFailed    = "failed"
+)
+
+// MaxRows bounds a single import.
+const MaxRows = 5000
+
+type Row struct {
+	Line  int
+	Name  string
+	Email string
+	Role  string
+}
+
+// Result reports what happened to one roster line. ActivationURL is set for
+// accounts that still need to be activated, and is the only copy of the
+// token in it.
+type Result struct {
+	Line          int    `json:"line"`
+	Email         string `json:"email"`
+	Status        string `json:"status"`
+	UserID        int    `json:"user_id,omitempty"`
+	Username      string `json:"username,omitempty"`
+	ActivationURL string `json:"activation_url,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Parse reads a roster. The first line must name the columns; "name" and
+// "email" are required, "role" is optional, and others are ignored. Lines
+// that do not validate come back as failed results rather than an error,
+// which is reserved for files that cannot be read at all.
+func Parse(r io.Reader) ([]Row, []Result, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("roster: reading header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	nameCol, hasName := columns["name"]
+	emailCol, hasEmail := columns["email"]
+	roleCol, hasRole := columns["role"]
+	if !hasName || !hasEmail {
+		return nil, nil, errors.New("roster: header needs name and email columns")
+	}
+
+	field := func(record []string, i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []Row
+	var failed []Result
+	seen := map[string]int{}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				failed = append(failed, Result{Line: parseErr.StartLine, Status: StatusFailed, Error: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, fmt.Errorf("roster: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if len(rows)+len(failed) >= MaxRows {
+			return nil, nil, fmt.Errorf("roster: more than %d rows", MaxRows)
+		}
+
+		row := Row{
+			Line:  line,
+			Name:  field(record, nameCol),
+			Email: field(record, emailCol),
+		}
+		if hasRole {
+			row.Role = strings.ToLower(field(record, roleCol))
+		}
+
+		if row.Name == "" && row.Email == "" && row.Role == "" {
+			continue
+		}
+
+		if err := validate(&row); err != nil {
+			failed = append(failed, Result{Line: line, Email: row.Email, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+
+		key := strings.ToLower(row.Email)
+		if first, dup := seen[key]; dup {
+			failed = append(failed, Result{Line: line, Email: row.Email, Status: StatusFailed, Error: "duplicate of line " + strconv.Itoa(first)})
+			continue
+		}
+		seen[key] = line
+
+		rows = append(rows, row)
+	}
+
+	return rows, failed, nil
+}
+
+func validate(row *Row) error {
+	if row.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(row.Name) > 100 {
+		return errors.New("name must be at most 100 characters")
+	}
+
+	addr, err := mail.ParseAddress(row.Email)
+	if err != nil || addr.Address != row.Email {
+		return errors.New("invalid email address")
+	}
+
+	// Rosters cannot hand out admin rights.
+	switch row.Role {
+	case "":
+	case models.RoleUser, models.RoleModerator:
+	default:
+		return fmt.Errorf("role must be %s or %s", models.RoleUser, models.RoleModerator)
+	}
+
+	return nil
+}
+
+type Importer struct {
+	DB *models.DBModel
+	// FrontendURL is where activation links point, as in
+	// <FrontendURL>/activate?token=...
+	FrontendURL   string
+	ActivationTTL time.Duration
+}
+
+// Import creates or updates the account of every row.
+func (im *Importer) Import(rows []Row) []Result {
+	results := make([]Result, 0, len(rows))
+	for _, row := range rows {
+		result, err := im.importRow(row)
+		if err != nil {
+			result = Result{Line: row.Line, Email: row.Email, Status: StatusFailed, Error: err.Error()}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (im *Importer) importRow(row Row) (Result, error) {
+	result := Result{Line: row.Line, Email: row.Email}
+
+	user, err := im.DB.GetUserByEmail(row.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = im.create(row)
+		if err != nil {
+			return result, err
+		}
+		result.Status = StatusCreated
+
+	case err != nil:
+		return result, err
+
+	default:
+		// An unverified address proves nothing about who owns the account,
+		// unless an earlier import created it.
+		if user.EmailVerifiedAt == nil {
+			rostered, err := im.DB.IsRosterUser(user.UserID)
+			if err != nil {
+				return result, err
+			}
+			if !rostered {
+				return result, errors.New("an account with this email exists but its email is not verified")
+			}
+		}
+
+		result.Status, err = im.update(user, row)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result.UserID = user.UserID
+	result.Username = user.Username
+
+	// Accounts without a password nor verified email were never used.
+	if user.Password == "" && user.EmailVerifiedAt == nil {
+		result.ActivationURL, err = im.activationLink(user)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func (im *Importer) create(row Row) (*models.User, error) {
+	username, err := im.freeUsername(row.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:    username,
+		DisplayName: row.Name,
+		Email:       row.Email,
+		Role:        row.Role,
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+
+	err = im.DB.InsertRosterUser(user)
+	if err != nil {
+		return nil, errors.New("could not create the account")
+	}
+
+	return user, nil
+}
+
+func (im *Importer) update(user *models.User, row Row) (string, error) {
+	status := StatusUnchanged
+
+	if row.Name != user.DisplayName {
+		err := im.DB.SetDisplayName(user.UserID, row.Name)
+		if err != nil {
+			return "", err
+		}
+		user.DisplayName = row.Name
+		status = StatusUpdated
+	}
+
+	// Admins keep their role whatever the roster says.
+	if row.Role != "" && row.Role != user.Role && user.Role != models.RoleAdmin {
+		err := im.DB.SetUserRole(user.UserID, row.Role)
+		if err != nil {
+			return "", err
+		}
+		user.Role = row.Role
+		status = StatusUpdated
+	}
+
+	return status, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// freeUsername derives an unused username from the email's local part.
+func (im *Importer) freeUsername(email string) (string, error) {
+	base := email[:strings.LastIndexByte(email, '@')]
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(base, ""), ".-")
+	if len(base) > 30 {
+		base = base[:30]
+	}
+	if base == "" {
+		base = "student"
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = base + strconv.Itoa(i)
+		}
+
+		_, err := im.DB.GetUserByUsername(candidate)
+		if errors.Is(err, sql.ErrNoRows) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", errors.New("no free username for " + base)
+}
+
+// activationLink replaces any earlier activation token of user.
+func (im *Importer) activationLink(user *models.User) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	hash := sha256.Sum256([]byte(token))
+
+	err = im.DB.DeleteUserTokens(user.UserID, models.ScopeActivation)
+	if err != nil {
+		return "", err
+	}
+
+	err = im.DB.InsertUserToken(hash[:], user.UserID, models.ScopeActivation, time.Now().Add(im.ActivationTTL))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/activate?token=%s", im.FrontendURL, url.QueryEscape(token)), nil
+}
+
+// Summary counts results by status.
+func Summary(results []Result) map[string]int {
+	summary := map[string]int{StatusCreated: 0, StatusUpdated: 0, StatusUnchanged: 0, StatusFailed: 0}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
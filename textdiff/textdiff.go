@@ -0,0 +1,203 @@
+// Package textdiff renders line-based unified diffs of short texts, such as
+// successive revisions of a reply.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+// MaxLines bounds the changed region compared line by line, as comparing
+// takes time and memory proportional to the product of both sides. Larger
+// changes are shown as replacing the whole region.
+const MaxLines = 500
+
+type edit struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns the unified diff turning from into to, labelled with
+// fromName and toName, or "" when the texts have the same lines.
+func Unified(from, to, fromName, toName string) string {
+	edits := diffLines(splitLines(from), splitLines(to))
+
+	var b strings.Builder
+	for _, h := range hunks(edits) {
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		b.WriteString(h)
+	}
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns a shortest edit script from a to b. Common leading and
+// trailing lines are matched first, so the quadratic longest common
+// subsequence only runs over the part that changed, and only up to
+// MaxLines.
+func diffLines(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, l := range a[:prefix] {
+		edits = append(edits, edit{' ', l})
+	}
+
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(x) > MaxLines || len(y) > MaxLines {
+		for _, l := range x {
+			edits = append(edits, edit{'-', l})
+		}
+		for _, l := range y {
+			edits = append(edits, edit{'+', l})
+		}
+		for _, l := range a[len(a)-suffix:] {
+			edits = append(edits, edit{' ', l})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i]})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	for _, l := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', l})
+	}
+	return edits
+}
+
+// hunks groups the changes in edits, with Context lines around them, into
+// formatted hunks.
+func hunks(edits []edit) []string {
+	var out []string
+
+	for start := 0; start < len(edits); {
+		// Find the next change.
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		// Extend the hunk while the next change is close enough for the
+		// contexts to touch.
+		last := first
+		for k := first + 1; k < len(edits); k++ {
+			if edits[k].kind == ' ' {
+				continue
+			}
+			if k-last > 2*Context {
+				break
+			}
+			last = k
+		}
+
+		from := first - Context
+		if from < start {
+			from = start
+		}
+		to := last + Context + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		out = append(out, formatHunk(edits, from, to))
+		start = to
+	}
+
+	return out
+}
+
+func formatHunk(edits []edit, from, to int) string {
+	oldLine, newLine := 1, 1
+	for _, e := range edits[:from] {
+		if e.kind != '+' {
+			oldLine++
+		}
+		if e.kind != '-' {
+			newLine++
+		}
+	}
+
+	var body strings.Builder
+	oldCount, newCount := 0, 0
+	for _, e := range edits[from:to] {
+		if e.kind != '+' {
+			oldCount++
+		}
+		if e.kind != '-' {
+			newCount++
+		}
+		body.WriteByte(e.kind)
+		body.WriteString(e.line)
+		body.WriteByte('\n')
+	}
+
+	// An empty range names the line before it, as diff -u does.
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+
+	return fmt.Sprintf("@@ -%s +%s @@\n%s", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount), body.String())
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,98 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func lines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "same text",
+			from: "a\nb\n",
+			to:   "a\nb",
+			want: "",
+		},
+		{
+			name: "both empty",
+			want: "",
+		},
+		{
+			name: "from empty",
+			to:   "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "to empty",
+			from: "a\n",
+			want: "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "changed line in the middle",
+			from: lines(1, 10),
+			to:   strings.Replace(lines(1, 10), "line 5\n", "line five\n", 1),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n line 2\n line 3\n line 4\n-line 5\n+line five\n line 6\n line 7\n line 8\n",
+		},
+		{
+			name: "insertion after the first line",
+			from: lines(1, 5),
+			to:   "line 1\nnew\n" + lines(2, 5),
+			want: "--- old\n+++ new\n@@ -1,4 +1,5 @@\n line 1\n+new\n line 2\n line 3\n line 4\n",
+		},
+		{
+			name: "changes whose contexts touch share a hunk",
+			from: lines(1, 12),
+			to:   strings.NewReplacer("line 2\n", "two\n", "line 8\n", "eight\n").Replace(lines(1, 12)),
+			want: "--- old\n+++ new\n@@ -1,11 +1,11 @@\n line 1\n-line 2\n+two\n line 3\n line 4\n line 5\n line 6\n line 7\n-line 8\n+eight\n line 9\n line 10\n line 11\n",
+		},
+		{
+			name: "distant changes get their own hunks",
+			from: lines(1, 20),
+			to:   strings.NewReplacer("line 2\n", "two\n", "line 18\n", "eighteen\n").Replace(lines(1, 20)),
+			want: "--- old\n+++ new\n@@ -1,5 +1,5 @@\n line 1\n-line 2\n+two\n line 3\n line 4\n line 5\n@@ -15,6 +15,6 @@\n line 15\n line 16\n line 17\n-line 18\n+eighteen\n line 19\n line 20\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Unified(tc.from, tc.to, "old", "new")
+			if got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedLargeChange(t *testing.T) {
+	from := "head\n" + lines(1, MaxLines+1) + "tail\n"
+	to := "head\n" + strings.ReplaceAll(lines(1, MaxLines+1), "line", "row") + "tail\n"
+
+	got := Unified(from, to, "old", "new")
+
+	header := fmt.Sprintf("--- old\n+++ new\n@@ -1,%d +1,%d @@\n head\n", MaxLines+3, MaxLines+3)
+	if !strings.HasPrefix(got, header) {
+		t.Fatalf("got header %q, want %q", got[:len(header)], header)
+	}
+	if strings.Count(got, "@@ -") != 1 {
+		t.Errorf("got %d hunks, want one", strings.Count(got, "@@ -"))
+	}
+	if !strings.Contains(got, fmt.Sprintf("-line %d\n+row 1\n", MaxLines+1)) {
+		t.Error("region is not replaced as a whole")
+	}
+	if !strings.HasSuffix(got, "\n tail\n") {
+		t.Error("trailing context missing")
+	}
+}